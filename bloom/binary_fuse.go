@@ -0,0 +1,306 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// `ErrBuildFailed`: a static filter could not be constructed from its keys
+var ErrBuildFailed = errors.New("bloom: construction failed")
+
+// maximum number of seeds tried before giving up on a construction
+const fuseMaxIterations = 1000
+
+// `BinaryFuse`: static binary fuse filter (Graf & Lemire) with 8-bit fingerprints,
+// about 9 bits per key at 0.4% false positives, Seeds[0] hashes the keys and
+// Seeds[1] starts the sequence of construction seeds
+type BinaryFuse struct {
+	ID                 string
+	Seeds              [2]uint64
+	Seed               uint64
+	SegmentLength      uint32
+	SegmentLengthMask  uint32
+	SegmentCount       uint32
+	SegmentCountLength uint32
+	Fingerprints       []uint8
+}
+
+// `NewBinaryFuseDefault` return a default `BinaryFuse` built from keys
+func NewBinaryFuseDefault(id string, keys []any) (*BinaryFuse, error) {
+	return NewBinaryFuseCustom(id, keys, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBinaryFuseCustom` return a custom `BinaryFuse` built from keys
+func NewBinaryFuseCustom(id string, keys []any, seeds [2]uint64) (*BinaryFuse, error) {
+	return NewBinaryFuseFromSeq(id, slices.Values(keys), seeds)
+}
+
+// `NewBinaryFuseFromSeq` return a custom `BinaryFuse` built from an iterator of keys
+func NewBinaryFuseFromSeq(id string, keys iter.Seq[any], seeds [2]uint64) (*BinaryFuse, error) {
+	// hash the keys, duplicates would never peel
+	hashes := make([]uint64, 0)
+	for key := range keys {
+		hashes = append(hashes, hash(seeds[0], toBytes(key)))
+	}
+	slices.Sort(hashes)
+	hashes = slices.Compact(hashes)
+
+	layout := newFuseLayout(uint32(len(hashes)))
+
+	// find a seed for which all keys peel
+	peel, err := layout.peel(hashes, seeds[1])
+	if err != nil {
+		return nil, err
+	}
+
+	filter := BinaryFuse{
+		ID:                 id,
+		Seeds:              seeds,
+		Seed:               peel.seed,
+		SegmentLength:      layout.SegmentLength,
+		SegmentLengthMask:  layout.SegmentLengthMask,
+		SegmentCount:       layout.SegmentCount,
+		SegmentCountLength: layout.SegmentCountLength,
+		Fingerprints:       make([]uint8, layout.arrayLength()),
+	}
+
+	// assign fingerprints in reverse peeling order
+	peel.assign(&layout, func(h uint64) uint64 {
+		return fuseFingerprint(h)
+	}, func(i uint32) uint64 {
+		return uint64(filter.Fingerprints[i])
+	}, func(i uint32, v uint64) {
+		filter.Fingerprints[i] = uint8(v)
+	})
+
+	return &filter, nil
+}
+
+// `Check`: check a value to the set (false negative: never, false positives: maybe)
+func (b *BinaryFuse) Check(value any) bool {
+	h := mix64(hash(b.Seeds[0], toBytes(value)) + b.Seed)
+	f := uint8(fuseFingerprint(h))
+
+	h0, h1, h2 := b.layout().positions(h)
+	f ^= b.Fingerprints[h0] ^ b.Fingerprints[h1] ^ b.Fingerprints[h2]
+	return f == 0
+}
+
+// `layout`: segment layout of the filter
+func (b *BinaryFuse) layout() *fuseLayout {
+	return &fuseLayout{
+		SegmentLength:      b.SegmentLength,
+		SegmentLengthMask:  b.SegmentLengthMask,
+		SegmentCount:       b.SegmentCount,
+		SegmentCountLength: b.SegmentCountLength,
+	}
+}
+
+// `GetFalsePositiveProbabilityEstimate`: false positive rate of 8-bit fingerprints
+func (b *BinaryFuse) GetFalsePositiveProbabilityEstimate() float64 {
+	return 1.0 / 256
+}
+
+// `Save`: save the filter to dir/id.fuse
+func (b *BinaryFuse) Save(dir string) error {
+	return saveGob(dir, b.ID+".fuse", b)
+}
+
+// `Load`: load the filter from dir/id.fuse
+func (b *BinaryFuse) Load(dir string) error {
+	return loadGob(dir, b.ID+".fuse", b)
+}
+
+// `fuseLayout`: segment layout of a 3-wise binary fuse array
+type fuseLayout struct {
+	SegmentLength      uint32
+	SegmentLengthMask  uint32
+	SegmentCount       uint32
+	SegmentCountLength uint32
+}
+
+// `newFuseLayout`: return the segment layout for size keys
+func newFuseLayout(size uint32) fuseLayout {
+	var l fuseLayout
+
+	// these parameters come from the reference implementation
+	l.SegmentLength = 4
+	if size > 0 {
+		l.SegmentLength = uint32(1) << int(math.Floor(math.Log(float64(size))/math.Log(3.33)+2.25))
+	}
+	l.SegmentLength = min(l.SegmentLength, 262144)
+	l.SegmentLengthMask = l.SegmentLength - 1
+
+	capacity := uint32(0)
+	if size > 1 {
+		size_factor := math.Max(1.125, 0.875+0.25*math.Log(1000000)/math.Log(float64(size)))
+		capacity = uint32(math.Round(float64(size) * size_factor))
+	}
+
+	// the array holds SegmentCount+2 segments
+	l.SegmentCount = max((capacity+l.SegmentLength-1)/l.SegmentLength, 3) - 2
+	l.SegmentCountLength = l.SegmentCount * l.SegmentLength
+	return l
+}
+
+// `arrayLength`: number of slots in the array
+func (l *fuseLayout) arrayLength() uint32 {
+	return (l.SegmentCount + 2) * l.SegmentLength
+}
+
+// `positions`: the three slots of a mixed hash, one in each of 3 consecutive segments
+func (l *fuseLayout) positions(h uint64) (uint32, uint32, uint32) {
+	hi, _ := bits.Mul64(h, uint64(l.SegmentCountLength))
+	h0 := uint32(hi)
+	h1 := h0 + l.SegmentLength
+	h2 := h1 + l.SegmentLength
+	h1 ^= uint32(h>>18) & l.SegmentLengthMask
+	h2 ^= uint32(h) & l.SegmentLengthMask
+	return h0, h1, h2
+}
+
+// `fusePeeling`: order in which keys were peeled, reversed when assigning
+type fusePeeling struct {
+	seed   uint64
+	hashes []uint64 // mixed hashes in peeling order
+	found  []uint8  // which of the 3 slots was free for each key
+}
+
+// `peel`: find a construction seed for which the hypergraph of the distinct hashes peels
+func (l *fuseLayout) peel(hashes []uint64, seed uint64) (fusePeeling, error) {
+	size := uint32(len(hashes))
+	capacity := l.arrayLength()
+
+	// the lowest 2 bits of count xor the slot numbers, the rest count the keys
+	count := make([]uint8, capacity)
+	xor := make([]uint64, capacity)
+	alone := make([]uint32, capacity)
+
+	peeling := fusePeeling{
+		hashes: make([]uint64, size),
+		found:  make([]uint8, size),
+	}
+
+	rng := seed
+	for iteration := 0; iteration < fuseMaxIterations; iteration++ {
+		peeling.seed = splitmix64(&rng)
+
+		for i := range count {
+			count[i] = 0
+			xor[i] = 0
+		}
+
+		// add all keys to the array
+		overflow := false
+		for _, base := range hashes {
+			h := mix64(base + peeling.seed)
+			h0, h1, h2 := l.positions(h)
+
+			count[h0] += 4
+			xor[h0] ^= h
+			count[h1] += 4
+			count[h1] ^= 1
+			xor[h1] ^= h
+			count[h2] += 4
+			count[h2] ^= 2
+			xor[h2] ^= h
+
+			// a slot holding 64 keys overflows the counter
+			if count[h0] < 4 || count[h1] < 4 || count[h2] < 4 {
+				overflow = true
+				break
+			}
+		}
+		if overflow {
+			continue
+		}
+
+		// queue the slots holding a single key
+		n_alone := 0
+		for i := uint32(0); i < capacity; i++ {
+			alone[n_alone] = i
+			if count[i]>>2 == 1 {
+				n_alone++
+			}
+		}
+
+		// peel keys until no slot holds a single key
+		n_peeled := uint32(0)
+		var h012 [5]uint32
+		for n_alone > 0 {
+			n_alone--
+			index := alone[n_alone]
+			if count[index]>>2 != 1 {
+				continue
+			}
+
+			h := xor[index]
+			found := count[index] & 3
+			peeling.hashes[n_peeled] = h
+			peeling.found[n_peeled] = found
+			n_peeled++
+
+			h0, h1, h2 := l.positions(h)
+			h012[0], h012[1], h012[2], h012[3], h012[4] = h0, h1, h2, h0, h1
+
+			// remove the key from its two other slots
+			for j := uint8(1); j <= 2; j++ {
+				other := h012[found+j]
+				alone[n_alone] = other
+				if count[other]>>2 == 2 {
+					n_alone++
+				}
+				count[other] -= 4
+				count[other] ^= (found + j) % 3
+				xor[other] ^= h
+			}
+		}
+
+		if n_peeled == size {
+			return peeling, nil
+		}
+	}
+
+	return peeling, fmt.Errorf("%w: no peelable seed after %d attempts", ErrBuildFailed, fuseMaxIterations)
+}
+
+// `assign`: fill the array so that the xor of the 3 slots of each key equals value(hash)
+func (p *fusePeeling) assign(l *fuseLayout, value func(uint64) uint64, get func(uint32) uint64, set func(uint32, uint64)) {
+	var h012 [5]uint32
+	for i := len(p.hashes) - 1; i >= 0; i-- {
+		h := p.hashes[i]
+		h0, h1, h2 := l.positions(h)
+		h012[0], h012[1], h012[2], h012[3], h012[4] = h0, h1, h2, h0, h1
+
+		found := p.found[i]
+		set(h012[found], value(h)^get(h012[found+1])^get(h012[found+2]))
+	}
+}
+
+// `fuseFingerprint`: 8-bit fingerprint of a mixed hash
+func fuseFingerprint(h uint64) uint64 {
+	return (h ^ (h >> 32)) & 0xff
+}
+
+// `mix64`: murmur3 finalizer, a bijective mix of 64 bits
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// `splitmix64`: next value of a splitmix64 sequence
+func splitmix64(state *uint64) uint64 {
+	*state += 0x9e3779b97f4a7c15
+	z := *state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
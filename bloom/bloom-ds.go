@@ -1,9 +1,9 @@
 package bloom
 
 import (
-	"encoding/gob"
-	"os"
-	"path/filepath"
+	"errors"
+	"fmt"
+	"sync/atomic"
 )
 
 type BloomDS struct {
@@ -12,6 +12,9 @@ type BloomDS struct {
 	NHash  uint64
 	Seeds  [2]uint64
 	Filter []uint64
+	Hasher string // registered hasher name, empty for murmur3, set it with SetHasher
+
+	hasher Hasher // resolved from Hasher, cached
 }
 
 // `NewBloomDSDefault`: return default bloom_ds
@@ -27,6 +30,7 @@ func NewBloomDSCustom(id string, n_bits, n_hash uint64, seeds [2]uint64) BloomDS
 		NHash:  n_hash,
 		Seeds:  seeds,
 		Filter: make([]uint64, (n_bits+63)/64),
+		hasher: murmur3Hasher{},
 	}
 }
 
@@ -37,9 +41,9 @@ func (b *BloomDS) Reset() {
 	}
 }
 
-// `Union`: union with another bloom_ds with same n_bits and seeds
+// `Union`: union with another bloom_ds with same n_bits, seeds and hasher
 func (b1 *BloomDS) Union(b2 *BloomDS) bool {
-	if b1.NBits != b2.NBits || b1.NHash != b2.NHash || b1.Seeds != b2.Seeds {
+	if !b1.compatible(b2) {
 		return false
 	}
 	for i := range b1.Filter {
@@ -50,50 +54,145 @@ func (b1 *BloomDS) Union(b2 *BloomDS) bool {
 
 // `GetIndices`: get indices that would be considered for a value
 func (b *BloomDS) GetIndices(value any) []uint64 {
-	// get bytes
-	data := toBytes(value)
+	return b.getIndices(toBytes(value))
+}
 
-	// get primary hashes
-	h1 := hash(b.Seeds[0], data) % b.NBits
-	h2 := hash(b.Seeds[1], data) % b.NBits
+// `compatible`: true if b2 has the same n_bits, n_hash, seeds and hasher, so its bits mean
+// the same values
+func (b1 *BloomDS) compatible(b2 *BloomDS) bool {
+	return b1.NBits == b2.NBits && b1.NHash == b2.NHash && b1.Seeds == b2.Seeds && b1.hasherName() == b2.hasherName()
+}
+
+// `SetHasher`: select the registered hasher used for the indices, only on an empty filter,
+// the filters built on a bloom_ds use it, `BloomStable`, `BloomRotating`, `Set`, `CountMin`,
+// `MinHash`, `HyperLogLog` and `IBLT` always hash with murmur3
+func (b *BloomDS) SetHasher(name string) error {
+	h, found := LookupHasher(name)
+	if !found {
+		return fmt.Errorf("bloom: unknown hasher %q", name)
+	}
+	for _, word := range b.Filter {
+		if word != 0 {
+			return errors.New("bloom: hasher changed on a non-empty filter")
+		}
+	}
 
-	// use double hashing to generate n_hash indices
-	indices := make([]uint64, b.NHash)
-	m := b.NBits
+	b.Hasher = name
+	b.hasher = h
+	return nil
+}
 
-	for i := uint64(0); i < uint64(b.NHash); i++ {
-		index := (h1 + (i*h2)%m) % m
-		indices[i] = index
+// `hasherName`: name of the hasher, murmur3 if unset
+func (b *BloomDS) hasherName() string {
+	if b.Hasher == "" {
+		return HasherMurmur3
 	}
+	return b.Hasher
+}
 
-	return indices
+// `getHasher`: the hasher of the filter, resolved by name and cached the first time for a
+// bloom_ds decoded or built by hand, murmur3 if the name is not registered (the constructors,
+// `SetHasher` and `Load` report that as an error)
+func (b *BloomDS) getHasher() Hasher {
+	if b.hasher == nil {
+		h, found := LookupHasher(b.Hasher)
+		if !found {
+			return murmur3Hasher{}
+		}
+		b.hasher = h
+	}
+	return b.hasher
 }
 
-// `Save`: save bloom_ds to dir/id.bloom
-func (b *BloomDS) Save(dir string) error {
-	// make dir if it doesnt exist
-	err := os.MkdirAll(dir, 0644)
-	if err != nil {
-		return err
+// `loadHasher`: resolve and cache the hasher of the filter, an error if it is not registered
+func (b *BloomDS) loadHasher() error {
+	h, found := LookupHasher(b.Hasher)
+	if !found {
+		return fmt.Errorf("bloom: unknown hasher %q", b.Hasher)
 	}
+	b.hasher = h
+	return nil
+}
 
-	// save to the file
-	fname := filepath.Join(dir, b.ID+".bloom")
-	f, err := os.Create(fname)
-	if err != nil {
-		return err
+// `copyHasher`: use the hasher of another bloom_ds, an error if it is not registered
+func (b *BloomDS) copyHasher(from *BloomDS) error {
+	b.Hasher = from.Hasher
+	b.hasher = nil
+	return b.loadHasher()
+}
+
+// `getIndices`: indices of data using the hasher of the filter
+func (b *BloomDS) getIndices(data []byte) []uint64 {
+	return getIndicesWith(b.getHasher(), data, b.NBits, b.NHash, b.Seeds)
+}
+
+// `hashPair`: primary hashes of data in [0, NBits), index i is (h1 + i*h2) % NBits like
+// `getIndices`, without allocating the indices
+func (b *BloomDS) hashPair(data []byte) (uint64, uint64) {
+	h := b.getHasher()
+	return h.Sum64(b.Seeds[0], data) % b.NBits, h.Sum64(b.Seeds[1], data) % b.NBits
+}
+
+// `indexAt`: the i-th index of the primary hashes h1 and h2
+func (b *BloomDS) indexAt(h1, h2, i uint64) uint64 {
+	return (h1 + (i*h2)%b.NBits) % b.NBits
+}
+
+// `setAtomic`: set the bit at index using atomic operations
+func (b *BloomDS) setAtomic(index uint64) {
+	wi := index / 64
+	off := index % 64
+	mask := uint64(1) << off
+
+	for {
+		old := atomic.LoadUint64(&b.Filter[wi])
+		if old&mask != 0 {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&b.Filter[wi], old, old|mask) {
+			return
+		}
+	}
+}
+
+// `isSetAtomic`: check the bit at index using atomic operations
+func (b *BloomDS) isSetAtomic(index uint64) bool {
+	return atomic.LoadUint64(&b.Filter[index/64])&(1<<(index%64)) != 0
+}
+
+// `addAtomic`: set the bits at indices using atomic operations
+func (b *BloomDS) addAtomic(indices []uint64) {
+	for _, index := range indices {
+		b.setAtomic(index)
 	}
-	defer f.Close()
-	return gob.NewEncoder(f).Encode(b)
 }
 
-// `Load`: load bloom_ds from dir/id.bloom
+// `checkAtomic`: check the bits at indices using atomic operations
+func (b *BloomDS) checkAtomic(indices []uint64) bool {
+	for _, index := range indices {
+		if !b.isSetAtomic(index) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// `Save`: save bloom_ds to dir/id.bloom
+func (b *BloomDS) Save(dir string) error {
+	return saveGob(dir, b.ID+".bloom", b)
+}
+
+// `Load`: load bloom_ds from dir/id.bloom, an error if its hasher is not registered
 func (b *BloomDS) Load(dir string) error {
-	fname := filepath.Join(dir, b.ID+".bloom")
-	f, err := os.Open(fname)
-	if err != nil {
+	// gob skips zero fields, so decode into a zero bloom_ds and not over the current one
+	loaded := BloomDS{}
+	if err := loadGob(dir, b.ID+".bloom", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.loadHasher(); err != nil {
 		return err
 	}
-	defer f.Close()
-	return gob.NewDecoder(f).Decode(b)
+	*b = loaded
+	return nil
 }
@@ -1,36 +1,72 @@
 package bloom
 
-type Bloom struct {
+type Bloom[T any] struct {
 	State BloomDS
+
+	encoder KeyEncoder[T]
 }
 
 // `NewBloomDefault` return a default `Bloom` object
-func NewBloomDefault(id string, n_bits, n_hash uint64) *Bloom {
+func NewBloomDefault(id string, n_bits, n_hash uint64) *Bloom[any] {
 	return NewBloomCustom(id, n_bits, n_hash, [2]uint64{DefaultSeed1, DefaultSeed2})
 }
 
 // `NewBloomCustom` return a custom `Bloom` object
-func NewBloomCustom(id string, n_bits, n_hash uint64, seeds [2]uint64) *Bloom {
-	bloom := Bloom{
-		State: NewBloomDSCustom(id, n_bits, n_hash, seeds),
+func NewBloomCustom(id string, n_bits, n_hash uint64, seeds [2]uint64) *Bloom[any] {
+	return NewBloomTypedCustom[any](id, n_bits, n_hash, seeds, AnyEncoder{})
+}
+
+// `NewBloomFromBloomDS`: return a `Bloom` using the data from bloom_ds, an error if its
+// hasher is not registered
+func NewBloomFromBloomDS(b *BloomDS) (*Bloom[any], error) {
+	return NewBloomTypedFromBloomDS[any](b, AnyEncoder{})
+}
+
+// `NewBloomTypedDefault` return a default `Bloom` object for values encoded by encoder
+func NewBloomTypedDefault[T any](id string, n_bits, n_hash uint64, encoder KeyEncoder[T]) *Bloom[T] {
+	return NewBloomTypedCustom(id, n_bits, n_hash, [2]uint64{DefaultSeed1, DefaultSeed2}, encoder)
+}
+
+// `NewBloomTypedCustom` return a custom `Bloom` object for values encoded by encoder
+func NewBloomTypedCustom[T any](id string, n_bits, n_hash uint64, seeds [2]uint64, encoder KeyEncoder[T]) *Bloom[T] {
+	bloom := Bloom[T]{
+		State:   NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		encoder: encoder,
 	}
 	return &bloom
 }
 
-// `NewBloomFromBloomDS`: return a `Bloom` using the data from bloom_ds
-func NewBloomFromBloomDS(b *BloomDS) *Bloom {
-	bloom := NewBloomCustom(b.ID, b.NBits, b.NHash, b.Seeds)
+// `NewBloomTypedFromBloomDS`: return a `Bloom` for values encoded by encoder using the
+// data from bloom_ds, an error if its hasher is not registered
+func NewBloomTypedFromBloomDS[T any](b *BloomDS, encoder KeyEncoder[T]) (*Bloom[T], error) {
+	bloom := NewBloomTypedCustom(b.ID, b.NBits, b.NHash, b.Seeds, encoder)
+	if err := bloom.State.copyHasher(b); err != nil {
+		return nil, err
+	}
 	bloom.Union(b)
-	return bloom
+	return bloom, nil
 }
 
 // `Add`: add a value to the set
-func (b *Bloom) Add(value any) {
-	// find the indices
-	indices := b.State.GetIndices(value)
+func (b *Bloom[T]) Add(value T) {
+	b.add(hashKey(&b.State, b.encoder, value))
+}
+
+// `AddE`: add a value to the set, an error wrapping `ErrEncodeFailed` if it cannot be encoded
+func (b *Bloom[T]) AddE(value T) error {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return err
+	}
+	b.add(h1, h2)
+	return nil
+}
 
+// `add`: set the bits of the primary hashes h1 and h2
+func (b *Bloom[T]) add(h1, h2 uint64) {
 	// find word index and offset, and set it to true
-	for _, index := range indices {
+	for i := uint64(0); i < b.State.NHash; i++ {
+		index := b.State.indexAt(h1, h2, i)
 		wi := index / 64
 		off := index % 64
 		b.State.Filter[wi] |= (1 << off)
@@ -38,12 +74,25 @@ func (b *Bloom) Add(value any) {
 }
 
 // `Check`: check a value to the set (false negative: never, false positives: maybe)
-func (b *Bloom) Check(value any) bool {
-	// find the indices
-	indices := b.State.GetIndices(value)
+func (b *Bloom[T]) Check(value T) bool {
+	return b.check(hashKey(&b.State, b.encoder, value))
+}
+
+// `CheckE`: check a value to the set like `Check`, an error wrapping `ErrEncodeFailed` if it
+// cannot be encoded
+func (b *Bloom[T]) CheckE(value T) (bool, error) {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return false, err
+	}
+	return b.check(h1, h2), nil
+}
 
+// `check`: check the bits of the primary hashes h1 and h2
+func (b *Bloom[T]) check(h1, h2 uint64) bool {
 	// // find word index and offset, and check if it is false
-	for _, index := range indices {
+	for i := uint64(0); i < b.State.NHash; i++ {
+		index := b.State.indexAt(h1, h2, i)
 		wi := index / 64
 		off := index % 64
 
@@ -56,19 +105,20 @@ func (b *Bloom) Check(value any) bool {
 }
 
 // `Reset`: resets bloom_ds
-func (b *Bloom) Reset() {
+func (b *Bloom[T]) Reset() {
 	b.State.Reset()
 }
 
 // `Union`: tries state union
-func (b1 *Bloom) Union(b2 *BloomDS) bool {
+func (b1 *Bloom[T]) Union(b2 *BloomDS) bool {
 	return b1.State.Union(b2)
 }
 
 // `GetState`: return current State bool
-func (b *Bloom) GetState() BloomDS {
+func (b *Bloom[T]) GetState() BloomDS {
 	return b.State
 }
 
 // complie-time check
-var _ IBloom = (*Bloom)(nil)
+var _ IBloom = (*Bloom[any])(nil)
+var _ IBloomOf[string] = (*Bloom[string])(nil)
@@ -0,0 +1,182 @@
+package bloom
+
+import (
+	"math"
+)
+
+// `BloomAgePartitioned`: age-partitioned bloom filter (Shtul, Baquero & Almeida) over the
+// last inserts, State holds K+L slices of NSliceBits bits used as a ring (its NHash is K+L,
+// one index per slice), each Add sets one bit in the K newest slices and every NGeneration
+// Adds the oldest slice is cleared and becomes the newest one
+type BloomAgePartitioned struct {
+	State       BloomDS
+	K           uint64
+	L           uint64
+	NSliceBits  uint64
+	NGeneration uint64
+	Head        uint64
+	Count       uint64
+}
+
+// `NewBloomAgePartitionedDefault` return a default `BloomAgePartitioned` object,
+// slices are sized to be half full once they have aged through K generations
+func NewBloomAgePartitionedDefault(id string, k, l, n_generation uint64) *BloomAgePartitioned {
+	n_slice_bits := uint64(math.Ceil(float64(k*n_generation) / math.Ln2))
+	return NewBloomAgePartitionedCustom(id, k, l, n_slice_bits, n_generation, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomAgePartitionedCustom` return a custom `BloomAgePartitioned` object
+func NewBloomAgePartitionedCustom(id string, k, l, n_slice_bits, n_generation uint64, seeds [2]uint64) *BloomAgePartitioned {
+	bloom := BloomAgePartitioned{
+		State:       NewBloomDSCustom(id, (k+l)*n_slice_bits, k+l, seeds),
+		K:           k,
+		L:           l,
+		NSliceBits:  n_slice_bits,
+		NGeneration: max(n_generation, 1),
+	}
+	return &bloom
+}
+
+// `slice`: physical slice of the i-th newest slice
+func (b *BloomAgePartitioned) slice(i uint64) uint64 {
+	return (b.Head + i) % (b.K + b.L)
+}
+
+// `getIndices`: one bit index per physical slice for a value
+func (b *BloomAgePartitioned) getIndices(value any) []uint64 {
+	indices := getIndicesWith(b.State.getHasher(), toBytes(value), b.NSliceBits, b.K+b.L, b.State.Seeds)
+	for p := range indices {
+		indices[p] += uint64(p) * b.NSliceBits
+	}
+	return indices
+}
+
+// `shift`: drop the oldest slice and reuse it as the newest one
+func (b *BloomAgePartitioned) shift() {
+	n := b.K + b.L
+	b.Head = (b.Head + n - 1) % n
+
+	// clear the bits of the new slice
+	lo := b.slice(0) * b.NSliceBits
+	for index := lo; index < lo+b.NSliceBits; index++ {
+		b.State.Filter[index/64] &^= 1 << (index % 64)
+	}
+	b.Count = 0
+}
+
+// `Add`: add a value to the K newest slices, shifting when the generation is full
+func (b *BloomAgePartitioned) Add(value any) {
+	if b.Count >= b.NGeneration {
+		b.shift()
+	}
+
+	// find the indices
+	indices := b.getIndices(value)
+
+	// find word index and offset, and set it to true
+	for i := uint64(0); i < b.K; i++ {
+		index := indices[b.slice(i)]
+		b.State.Filter[index/64] |= 1 << (index % 64)
+	}
+	b.Count++
+}
+
+// `Check`: check a value is in K consecutive slices (false negative: never within the window, false positives: maybe)
+func (b *BloomAgePartitioned) Check(value any) bool {
+	// find the indices
+	indices := b.getIndices(value)
+
+	// look for a run of K set bits from the newest to the oldest slice
+	run := uint64(0)
+	for i := uint64(0); i < b.K+b.L; i++ {
+		index := indices[b.slice(i)]
+		if (b.State.Filter[index/64] & (1 << (index % 64))) == 0 {
+			run = 0
+			continue
+		}
+		run++
+		if run == b.K {
+			return true
+		}
+	}
+
+	return false
+}
+
+// `Reset`: resets all slices
+func (b *BloomAgePartitioned) Reset() {
+	b.State.Reset()
+	b.Head = 0
+	b.Count = 0
+}
+
+// `GetState`: return current State bool
+func (b *BloomAgePartitioned) GetState() BloomDS {
+	return b.State
+}
+
+// `GetFalsePositiveProbabilityEstimate`: worst case false positive rate of the filter
+func (b *BloomAgePartitioned) GetFalsePositiveProbabilityEstimate() float64 {
+	return GetAgePartitionedFalsePositiveEstimate(b.K, b.L, b.NSliceBits, b.NGeneration)
+}
+
+// `GetWindow`: return the (min, max) number of latest Adds that are remembered
+func (b *BloomAgePartitioned) GetWindow() (uint64, uint64) {
+	return GetAgePartitionedWindow(b.L, b.NGeneration)
+}
+
+// `Save`: save the filter to dir/id.apbf
+func (b *BloomAgePartitioned) Save(dir string) error {
+	return saveGob(dir, b.State.ID+".apbf", b)
+}
+
+// `Load`: load the filter from dir/id.apbf, replacing the window of the current one
+func (b *BloomAgePartitioned) Load(dir string) error {
+	// a zero Head or Count is not in the file, decoding over b would keep the current ones
+	loaded := BloomAgePartitioned{}
+	if err := loadGob(dir, b.State.ID+".apbf", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.State.loadHasher(); err != nil {
+		return err
+	}
+	*b = loaded
+	return nil
+}
+
+// `GetAgePartitionedWindow`: return the (min, max) number of latest Adds remembered by an
+// age-partitioned filter with l extra slices and n_generation Adds per generation
+func GetAgePartitionedWindow(l, n_generation uint64) (uint64, uint64) {
+	return l * n_generation, (l + 1) * n_generation
+}
+
+// `GetAgePartitionedFalsePositiveEstimate`: worst case false positive rate of an age-partitioned
+// filter, reached when a generation is full, as the probability of K consecutive set bits
+func GetAgePartitionedFalsePositiveEstimate(k, l, n_slice_bits, n_generation uint64) float64 {
+	m := float64(n_slice_bits)
+	g := float64(n_generation)
+
+	// no_run[r]: probability of no run of k so far, with a current run of length r
+	no_run := make([]float64, k)
+	no_run[0] = 1
+
+	for i := uint64(0); i < k+l; i++ {
+		// the i-th newest slice has received min(i+1, k) generations of Adds
+		fill := 1 - math.Pow(1-1/m, float64(min(i+1, k))*g)
+
+		next := make([]float64, k)
+		for r := uint64(0); r < k; r++ {
+			next[0] += no_run[r] * (1 - fill)
+			if r+1 < k {
+				next[r+1] += no_run[r] * fill
+			}
+		}
+		no_run = next
+	}
+
+	p_none := 0.0
+	for _, p := range no_run {
+		p_none += p
+	}
+	return 1 - p_none
+}
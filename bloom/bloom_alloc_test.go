@@ -0,0 +1,20 @@
+//go:build !race
+
+// the race detector makes sync.Pool drop buffers, so allocations are only checked without it
+
+package bloom
+
+import "testing"
+
+func TestBloomTypedAllocs(t *testing.T) {
+	strs := NewBloomTypedDefault[string]("typed", 1<<12, 4, StringEncoder{})
+	ints := NewBloomAtomicTypedDefault[uint64]("typed", 1<<12, 4, UintEncoder[uint64]{})
+
+	// common key types do not allocate
+	if n := testing.AllocsPerRun(100, func() { strs.Add("key"); strs.Check("key") }); n != 0 {
+		t.Fatalf("string key allocates %.1f times", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { ints.Add(7); ints.Check(7) }); n != 0 {
+		t.Fatalf("uint64 key allocates %.1f times", n)
+	}
+}
@@ -2,87 +2,115 @@ package bloom
 
 import (
 	"sync"
-	"sync/atomic"
 )
 
-type BloomAtomic struct {
+type BloomAtomic[T any] struct {
 	State  BloomDS
 	rareMu sync.RWMutex
+
+	encoder KeyEncoder[T]
 }
 
 // `NewBloomAtomicDefault` return a default `BloomAtomic` object
-func NewBloomAtomicDefault(id string, n_bits, n_hash uint64) *BloomAtomic {
+func NewBloomAtomicDefault(id string, n_bits, n_hash uint64) *BloomAtomic[any] {
 	return NewBloomAtomicCustom(id, n_bits, n_hash, [2]uint64{DefaultSeed1, DefaultSeed2})
 }
 
 // `NewBloomAtomicCustom` return a custom `BloomAtomic` object
-func NewBloomAtomicCustom(id string, n_bits, n_hash uint64, seeds [2]uint64) *BloomAtomic {
-	bloom := BloomAtomic{
-		State: NewBloomDSCustom(id, n_bits, n_hash, seeds),
+func NewBloomAtomicCustom(id string, n_bits, n_hash uint64, seeds [2]uint64) *BloomAtomic[any] {
+	return NewBloomAtomicTypedCustom[any](id, n_bits, n_hash, seeds, AnyEncoder{})
+}
+
+// `NewBloomAtomicFromBloomDS`: return a `BloomAtomic` using the data from the bloom_ds,
+// an error if its hasher is not registered
+func NewBloomAtomicFromBloomDS(b *BloomDS) (*BloomAtomic[any], error) {
+	return NewBloomAtomicTypedFromBloomDS[any](b, AnyEncoder{})
+}
+
+// `NewBloomAtomicTypedDefault` return a default `BloomAtomic` object for values encoded by encoder
+func NewBloomAtomicTypedDefault[T any](id string, n_bits, n_hash uint64, encoder KeyEncoder[T]) *BloomAtomic[T] {
+	return NewBloomAtomicTypedCustom(id, n_bits, n_hash, [2]uint64{DefaultSeed1, DefaultSeed2}, encoder)
+}
+
+// `NewBloomAtomicTypedCustom` return a custom `BloomAtomic` object for values encoded by encoder
+func NewBloomAtomicTypedCustom[T any](id string, n_bits, n_hash uint64, seeds [2]uint64, encoder KeyEncoder[T]) *BloomAtomic[T] {
+	bloom := BloomAtomic[T]{
+		State:   NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		encoder: encoder,
 	}
 	return &bloom
 }
 
-// `NewBloomAtomicFromBloomDS`: return a `BloomAtomic` using the data from the bloom_ds
-func NewBloomAtomicFromBloomDS(b *BloomDS) *BloomAtomic {
-	bloom := NewBloomAtomicCustom(b.ID, b.NBits, b.NHash, b.Seeds)
+// `NewBloomAtomicTypedFromBloomDS`: return a `BloomAtomic` for values encoded by encoder
+// using the data from the bloom_ds, an error if its hasher is not registered
+func NewBloomAtomicTypedFromBloomDS[T any](b *BloomDS, encoder KeyEncoder[T]) (*BloomAtomic[T], error) {
+	bloom := NewBloomAtomicTypedCustom(b.ID, b.NBits, b.NHash, b.Seeds, encoder)
+	if err := bloom.State.copyHasher(b); err != nil {
+		return nil, err
+	}
 	bloom.Union(b)
-	return bloom
+	return bloom, nil
 }
 
 // `Add`: add a value to the set
-func (b *BloomAtomic) Add(value any) {
+func (b *BloomAtomic[T]) Add(value T) {
+	b.add(hashKey(&b.State, b.encoder, value))
+}
+
+// `AddE`: add a value to the set, an error wrapping `ErrEncodeFailed` if it cannot be encoded
+func (b *BloomAtomic[T]) AddE(value T) error {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return err
+	}
+	b.add(h1, h2)
+	return nil
+}
+
+// `add`: set the bits of the primary hashes h1 and h2
+func (b *BloomAtomic[T]) add(h1, h2 uint64) {
 	// unionRW mutex
 	b.rareMu.RLock()
 	defer b.rareMu.RUnlock()
 
-	// find the indices
-	indices := b.State.GetIndices(value)
-
-	// find word index and offset, and set it to true
-	for _, index := range indices {
-		wi := index / 64
-		off := index % 64
-		mask := uint64(1) << off
-
-		for {
-			old := atomic.LoadUint64(&b.State.Filter[wi])
-			if old&mask != 0 {
-				break
-			}
-			if atomic.CompareAndSwapUint64(&b.State.Filter[wi], old, old|mask) {
-				break
-			}
-		}
+	// set the bits atomically
+	for i := uint64(0); i < b.State.NHash; i++ {
+		b.State.setAtomic(b.State.indexAt(h1, h2, i))
 	}
 }
 
 // `Check: check a value to the set (false negative: never, false positives: maybe)
-func (b *BloomAtomic) Check(value any) bool {
+func (b *BloomAtomic[T]) Check(value T) bool {
+	return b.check(hashKey(&b.State, b.encoder, value))
+}
+
+// `CheckE`: check a value to the set like `Check`, an error wrapping `ErrEncodeFailed` if it
+// cannot be encoded
+func (b *BloomAtomic[T]) CheckE(value T) (bool, error) {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return false, err
+	}
+	return b.check(h1, h2), nil
+}
+
+// `check`: check the bits of the primary hashes h1 and h2
+func (b *BloomAtomic[T]) check(h1, h2 uint64) bool {
 	// unionRW mutex
 	b.rareMu.RLock()
 	defer b.rareMu.RUnlock()
 
-	// find the indices
-	indices := b.State.GetIndices(value)
-
-	// // find word index and offset, and check if it is false
-	for _, index := range indices {
-		wi := index / 64
-		off := index % 64
-		mask := uint64(1) << off
-
-		v := atomic.LoadUint64(&b.State.Filter[wi])
-		if v&mask == 0 {
+	// check the bits atomically
+	for i := uint64(0); i < b.State.NHash; i++ {
+		if !b.State.isSetAtomic(b.State.indexAt(h1, h2, i)) {
 			return false
 		}
 	}
-
 	return true
 }
 
 // `Reset`: resets bloom_ds
-func (b *BloomAtomic) Reset() {
+func (b *BloomAtomic[T]) Reset() {
 	// unionRW mutex
 	b.rareMu.Lock()
 	defer b.rareMu.Unlock()
@@ -91,7 +119,7 @@ func (b *BloomAtomic) Reset() {
 }
 
 // `Union`: tries state union
-func (b1 *BloomAtomic) Union(b2 *BloomDS) bool {
+func (b1 *BloomAtomic[T]) Union(b2 *BloomDS) bool {
 	// unionRW mutex
 	b1.rareMu.Lock()
 	defer b1.rareMu.Unlock()
@@ -100,7 +128,7 @@ func (b1 *BloomAtomic) Union(b2 *BloomDS) bool {
 }
 
 // `GetState`: return current State bool
-func (b *BloomAtomic) GetState() BloomDS {
+func (b *BloomAtomic[T]) GetState() BloomDS {
 	// unionRW mutex
 	b.rareMu.Lock()
 	defer b.rareMu.Unlock()
@@ -109,4 +137,4 @@ func (b *BloomAtomic) GetState() BloomDS {
 }
 
 // complie-time check
-var _ IBloom = (*BloomAtomic)(nil)
+var _ IBloom = (*BloomAtomic[any])(nil)
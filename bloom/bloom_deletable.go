@@ -0,0 +1,229 @@
+package bloom
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// `BloomDeletable`: deletable bloom filter (Rothenberg et al.), the Filter is divided into
+// NRegions regions of consecutive bits and Collisions marks the regions where two values
+// set the same bit, bits of collision-free regions can be reset safely on removal
+type BloomDeletable[T any] struct {
+	State      BloomDS
+	NRegions   uint64
+	Collisions []uint64
+
+	encoder KeyEncoder[T]
+}
+
+// `NewBloomDeletableDefault` return a default `BloomDeletable` object
+func NewBloomDeletableDefault(id string, n_bits, n_hash, n_regions uint64) *BloomDeletable[any] {
+	return NewBloomDeletableCustom(id, n_bits, n_hash, n_regions, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomDeletableCustom` return a custom `BloomDeletable` object
+func NewBloomDeletableCustom(id string, n_bits, n_hash, n_regions uint64, seeds [2]uint64) *BloomDeletable[any] {
+	return NewBloomDeletableTypedCustom[any](id, n_bits, n_hash, n_regions, seeds, AnyEncoder{})
+}
+
+// `NewBloomDeletableTypedDefault` return a default `BloomDeletable` object for values encoded
+// by encoder
+func NewBloomDeletableTypedDefault[T any](id string, n_bits, n_hash, n_regions uint64, encoder KeyEncoder[T]) *BloomDeletable[T] {
+	return NewBloomDeletableTypedCustom(id, n_bits, n_hash, n_regions, [2]uint64{DefaultSeed1, DefaultSeed2}, encoder)
+}
+
+// `NewBloomDeletableTypedCustom` return a custom `BloomDeletable` object for values encoded
+// by encoder
+func NewBloomDeletableTypedCustom[T any](id string, n_bits, n_hash, n_regions uint64, seeds [2]uint64, encoder KeyEncoder[T]) *BloomDeletable[T] {
+
+	if n_regions == 0 || n_regions > n_bits {
+		fmt.Println("NewBloomDeletableCustom: n_regions not in [1,n_bits], using n_bits/64")
+		n_regions = max(n_bits/64, 1)
+	}
+
+	bloom := BloomDeletable[T]{
+		State:      NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		NRegions:   n_regions,
+		Collisions: make([]uint64, (n_regions+63)/64),
+		encoder:    encoder,
+	}
+	return &bloom
+}
+
+// `region`: region of a bit
+func (b *BloomDeletable[T]) region(index uint64) uint64 {
+	hi, lo := bits.Mul64(index, b.NRegions)
+	q, _ := bits.Div64(hi, lo, b.State.NBits)
+	return q
+}
+
+// `markCollision`: mark the region of a bit as collided
+func (b *BloomDeletable[T]) markCollision(index uint64) {
+	r := b.region(index)
+	b.Collisions[r/64] |= 1 << (r % 64)
+}
+
+// `collided`: true if the region of a bit had a collision
+func (b *BloomDeletable[T]) collided(index uint64) bool {
+	r := b.region(index)
+	return b.Collisions[r/64]&(1<<(r%64)) != 0
+}
+
+// `Add`: add a value to the set
+func (b *BloomDeletable[T]) Add(value T) {
+	b.add(hashKey(&b.State, b.encoder, value))
+}
+
+// `AddE`: add a value to the set, an error wrapping `ErrEncodeFailed` if it cannot be encoded
+func (b *BloomDeletable[T]) AddE(value T) error {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return err
+	}
+	b.add(h1, h2)
+	return nil
+}
+
+// `add`: set the bits of the primary hashes h1 and h2
+func (b *BloomDeletable[T]) add(h1, h2 uint64) {
+	// set the bits, a bit that is already set is a collision
+	for i := uint64(0); i < b.State.NHash; i++ {
+		index := b.State.indexAt(h1, h2, i)
+		wi := index / 64
+		off := index % 64
+
+		if b.State.Filter[wi]&(1<<off) != 0 {
+			b.markCollision(index)
+		}
+		b.State.Filter[wi] |= (1 << off)
+	}
+}
+
+// `Check`: check a value to the set (false negative: never, false positives: maybe)
+func (b *BloomDeletable[T]) Check(value T) bool {
+	return b.check(hashKey(&b.State, b.encoder, value))
+}
+
+// `CheckE`: check a value to the set like `Check`, an error wrapping `ErrEncodeFailed` if it
+// cannot be encoded
+func (b *BloomDeletable[T]) CheckE(value T) (bool, error) {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return false, err
+	}
+	return b.check(h1, h2), nil
+}
+
+// `check`: check the bits of the primary hashes h1 and h2
+func (b *BloomDeletable[T]) check(h1, h2 uint64) bool {
+	// find word index and offset, and check if it is false
+	for i := uint64(0); i < b.State.NHash; i++ {
+		index := b.State.indexAt(h1, h2, i)
+		wi := index / 64
+		off := index % 64
+
+		if (b.State.Filter[wi] & (1 << off)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// `Remove`: remove a value from the set by resetting its bits in collision-free regions,
+// true if at least one bit was reset so that the value is no longer reported, only values
+// known to have been added may be removed, a false positive shares its bits with other
+// members and removing it would make them false negatives, a value that does not check
+// is left alone and false is returned
+func (b *BloomDeletable[T]) Remove(value T) bool {
+	h1, h2 := hashKey(&b.State, b.encoder, value)
+	if !b.check(h1, h2) {
+		return false
+	}
+
+	removed := false
+	for i := uint64(0); i < b.State.NHash; i++ {
+		index := b.State.indexAt(h1, h2, i)
+		if b.collided(index) {
+			continue
+		}
+		b.State.Filter[index/64] &^= 1 << (index % 64)
+		removed = true
+	}
+	return removed
+}
+
+// `Reset`: resets bloom_ds and the collision bitmap
+func (b *BloomDeletable[T]) Reset() {
+	b.State.Reset()
+	for i := range b.Collisions {
+		b.Collisions[i] = 0
+	}
+}
+
+// `Union`: tries state union, the collisions of b2 are unknown so every region where
+// it has a bit set is marked as collided, nothing is marked if the union is refused
+func (b1 *BloomDeletable[T]) Union(b2 *BloomDS) bool {
+	if !b1.State.compatible(b2) {
+		return false
+	}
+	for wi, w := range b2.Filter {
+		for ; w != 0; w &= w - 1 {
+			b1.markCollision(uint64(wi)*64 + uint64(bits.TrailingZeros64(w)))
+		}
+	}
+	return b1.State.Union(b2)
+}
+
+// `Merge`: union with another `BloomDeletable` of the same shape, keeping the regions of
+// both collision bitmaps and marking the regions where both filters have the same bit set
+func (b1 *BloomDeletable[T]) Merge(b2 *BloomDeletable[T]) bool {
+	if b1.NRegions != b2.NRegions || !b1.State.compatible(&b2.State) {
+		return false
+	}
+	for i := range b1.Collisions {
+		b1.Collisions[i] |= b2.Collisions[i]
+	}
+	for wi, w := range b2.State.Filter {
+		for common := w & b1.State.Filter[wi]; common != 0; common &= common - 1 {
+			b1.markCollision(uint64(wi)*64 + uint64(bits.TrailingZeros64(common)))
+		}
+		b1.State.Filter[wi] |= w
+	}
+	return true
+}
+
+// `GetState`: return current State bool
+func (b *BloomDeletable[T]) GetState() BloomDS {
+	return b.State
+}
+
+// `GetDeletableFraction`: fraction of the regions that are collision-free
+func (b *BloomDeletable[T]) GetDeletableFraction() float64 {
+	collided := 0
+	for _, w := range b.Collisions {
+		collided += bits.OnesCount64(w)
+	}
+	return 1 - float64(collided)/float64(b.NRegions)
+}
+
+// `Save`: save the filter and the collision bitmap to dir/id.dlbf
+func (b *BloomDeletable[T]) Save(dir string) error {
+	return saveGob(dir, b.State.ID+".dlbf", b)
+}
+
+// `Load`: load the filter and the collision bitmap from dir/id.dlbf
+func (b *BloomDeletable[T]) Load(dir string) error {
+	loaded := BloomDeletable[T]{encoder: b.encoder}
+	if err := loadGob(dir, b.State.ID+".dlbf", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.State.loadHasher(); err != nil {
+		return err
+	}
+	*b = loaded
+	return nil
+}
+
+// complie-time check
+var _ IBloom = (*BloomDeletable[any])(nil)
@@ -0,0 +1,309 @@
+package bloom
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/netip"
+	"strings"
+	"unicode/utf8"
+)
+
+// host names of hosts files that are not blocklist entries
+var domainLocalNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+}
+
+// `BloomDomain`: bloom filter of domain names with suffix semantics, a name matches if it
+// or any of its parent domains was added, names are normalized before hashing
+type BloomDomain struct {
+	State    BloomDS
+	NDomains uint64
+}
+
+// `NewBloomDomainDefault` return a default `BloomDomain` object
+func NewBloomDomainDefault(id string, n_bits, n_hash uint64) *BloomDomain {
+	return NewBloomDomainCustom(id, n_bits, n_hash, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomDomainCustom` return a custom `BloomDomain` object
+func NewBloomDomainCustom(id string, n_bits, n_hash uint64, seeds [2]uint64) *BloomDomain {
+	bloom := BloomDomain{
+		State: NewBloomDSCustom(id, n_bits, n_hash, seeds),
+	}
+	return &bloom
+}
+
+// punycode parameters (RFC 3492)
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// `NormalizeDomain`: lower case a domain name and drop the trailing dot and a leading
+// wildcard label, non-ASCII labels are converted to punycode (xn--) so that "bücher.example"
+// and "xn--bcher-kva.example" are the same name, the full IDNA mapping is not applied, false
+// if the name is empty, is not valid UTF-8 or has an empty label or a space
+func NormalizeDomain(name string) (string, bool) {
+	// lower casing replaces invalid bytes, so validate first
+	if !utf8.ValidString(name) {
+		return "", false
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimSuffix(name, ".")
+	name = strings.TrimPrefix(name, "*.")
+
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return "", false
+	}
+
+	ascii := true
+	for label := range strings.SplitSeq(name, ".") {
+		if label == "" {
+			return "", false
+		}
+		for i := 0; i < len(label); i++ {
+			ascii = ascii && label[i] < utf8.RuneSelf
+		}
+	}
+	if ascii {
+		return name, true
+	}
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		labels[i] = punycodeLabel(label)
+	}
+	return strings.Join(labels, "."), true
+}
+
+// `punycodeLabel`: the xn-- form of a label with non-ASCII runes, ASCII labels unchanged
+func punycodeLabel(label string) string {
+	runes := []rune(label)
+
+	// basic code points first, then a delimiter if there were any
+	out := make([]byte, 0, len(label)+8)
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	n_basic := len(out)
+	if n_basic == len(runes) {
+		return label
+	}
+	if n_basic > 0 {
+		out = append(out, '-')
+	}
+
+	// insert the other code points in increasing order as variable length deltas
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for h := n_basic; h < len(runes); {
+		m := rune(utf8.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := min(max(k-bias, punyTMin), punyTMax)
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, h+1, h == n_basic)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return "xn--" + string(out)
+}
+
+// `punycodeDigit`: the character of a base 36 digit
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+// `punycodeAdapt`: bias adaptation after a delta is written
+func punycodeAdapt(delta, n_points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / n_points
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+// `Add`: add a normalized domain to the set, false if the name is not a valid domain
+func (b *BloomDomain) Add(domain string) bool {
+	name, ok := NormalizeDomain(domain)
+	if !ok {
+		return false
+	}
+
+	// find word index and offset, and set it to true
+	for _, index := range b.State.GetIndices(name) {
+		b.State.Filter[index/64] |= 1 << (index % 64)
+	}
+	b.NDomains++
+	return true
+}
+
+// `check`: check the bits of a normalized name
+func (b *BloomDomain) check(name string) bool {
+	for _, index := range b.State.GetIndices(name) {
+		if b.State.Filter[index/64]&(1<<(index%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// `CheckExact`: check a domain to the set without its parents (false negative: never,
+// false positives: maybe)
+func (b *BloomDomain) CheckExact(domain string) bool {
+	name, ok := NormalizeDomain(domain)
+	return ok && b.check(name)
+}
+
+// `Check`: check if a domain or any of its parent domains is in the set (false negative:
+// never, false positives: maybe)
+func (b *BloomDomain) Check(domain string) bool {
+	name, ok := NormalizeDomain(domain)
+	if !ok {
+		return false
+	}
+
+	// every suffix starting at a label
+	for {
+		if b.check(name) {
+			return true
+		}
+		dot := strings.IndexByte(name, '.')
+		if dot < 0 {
+			return false
+		}
+		name = name[dot+1:]
+	}
+}
+
+// `AddList`: add the domains of a hosts file ("0.0.0.0 example.com") or of a plain list
+// (one domain per line), comments start with #, local host names and addresses are
+// skipped, return the number of domains added
+func (b *BloomDomain) AddList(r io.Reader) (uint64, error) {
+	n_added := uint64(0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// hosts files start with an address
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			fields = fields[1:]
+		}
+		for _, field := range fields {
+			if _, err := netip.ParseAddr(field); err == nil {
+				continue
+			}
+			name, ok := NormalizeDomain(field)
+			if !ok || domainLocalNames[name] {
+				continue
+			}
+			if b.Add(name) {
+				n_added++
+			}
+		}
+	}
+
+	return n_added, scanner.Err()
+}
+
+// `Reset`: resets bloom_ds and the domain count
+func (b *BloomDomain) Reset() {
+	b.State.Reset()
+	b.NDomains = 0
+}
+
+// `Union`: tries state union, NDomains becomes the sum of both counts
+func (b1 *BloomDomain) Union(b2 *BloomDomain) bool {
+	if !b1.State.Union(&b2.State) {
+		return false
+	}
+	b1.NDomains += b2.NDomains
+	return true
+}
+
+// `GetState`: return current State bool
+func (b *BloomDomain) GetState() BloomDS {
+	return b.State
+}
+
+// `GetFalsePositiveProbabilityEstimate`: false positive rate of a `Check` of a name with
+// n_labels labels, each of its suffixes can be a false positive
+func (b *BloomDomain) GetFalsePositiveProbabilityEstimate(n_labels uint64) float64 {
+	p := GetFalsePositiveProbabilityEstimate(b.State.NBits, b.State.NHash, b.NDomains)
+	return 1 - math.Pow(1-p, float64(n_labels))
+}
+
+// `Save`: save bloom_ds and the domain count to dir/id.domain
+func (b *BloomDomain) Save(dir string) error {
+	return saveGob(dir, b.State.ID+".domain", b)
+}
+
+// `Load`: load bloom_ds and the domain count from dir/id.domain
+func (b *BloomDomain) Load(dir string) error {
+	loaded := BloomDomain{}
+	if err := loadGob(dir, b.State.ID+".domain", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.State.loadHasher(); err != nil {
+		return err
+	}
+	*b = loaded
+	return nil
+}
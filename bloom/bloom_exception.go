@@ -0,0 +1,174 @@
+package bloom
+
+import (
+	"errors"
+	"sync"
+)
+
+// `BloomException`: wrapper around an `IBloomOf` with an exact set of confirmed false
+// positives, a value in the exception set is reported absent even if the filter has it,
+// safe for concurrent use if the wrapped filter is
+type BloomException[T any] struct {
+	Bloom IBloomOf[T]
+
+	mu         sync.RWMutex
+	exceptions map[string]struct{}
+	encoder    KeyEncoder[T]
+}
+
+// `bloomExceptionFile`: persisted form of a `BloomException`
+type bloomExceptionFile struct {
+	State      BloomDS
+	Exceptions [][]byte
+}
+
+// `NewBloomException` return a `BloomException` wrapping b with no exceptions
+func NewBloomException(b IBloom) *BloomException[any] {
+	return NewBloomExceptionTyped[any](b, AnyEncoder{})
+}
+
+// `NewBloomExceptionTyped` return a `BloomException` wrapping b with no exceptions, the
+// exceptions are keyed by the bytes of encoder
+func NewBloomExceptionTyped[T any](b IBloomOf[T], encoder KeyEncoder[T]) *BloomException[T] {
+	bloom := BloomException[T]{
+		Bloom:      b,
+		exceptions: make(map[string]struct{}),
+		encoder:    encoder,
+	}
+	return &bloom
+}
+
+// `Add`: add a value to the set, removing it from the exceptions
+func (b *BloomException[T]) Add(value T) {
+	key := keyString(b.encoder, value)
+
+	b.mu.RLock()
+	_, found := b.exceptions[key]
+	b.mu.RUnlock()
+	if found {
+		b.mu.Lock()
+		delete(b.exceptions, key)
+		b.mu.Unlock()
+	}
+
+	b.Bloom.Add(value)
+}
+
+// `Check`: check a value to the set (false negative: never, false positives: maybe, but
+// never for a registered exception)
+func (b *BloomException[T]) Check(value T) bool {
+	if !b.Bloom.Check(value) {
+		return false
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	_, found := b.exceptions[keyString(b.encoder, value)]
+	return !found
+}
+
+// `AddException`: register a confirmed false positive, false if the filter does not
+// report the value anyway, the caller must be sure the value was never added
+func (b *BloomException[T]) AddException(value T) bool {
+	if !b.Bloom.Check(value) {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.exceptions[keyString(b.encoder, value)] = struct{}{}
+	return true
+}
+
+// `RemoveException`: unregister a false positive, false if it was not registered
+func (b *BloomException[T]) RemoveException(value T) bool {
+	key := keyString(b.encoder, value)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, found := b.exceptions[key]
+	delete(b.exceptions, key)
+	return found
+}
+
+// `GetExceptionCount`: number of registered exceptions
+func (b *BloomException[T]) GetExceptionCount() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return uint64(len(b.exceptions))
+}
+
+// `Reset`: resets the filter and the exceptions
+func (b *BloomException[T]) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Bloom.Reset()
+	b.exceptions = make(map[string]struct{})
+}
+
+// `Union`: tries state union, the exceptions are cleared since b2 may hold them
+func (b1 *BloomException[T]) Union(b2 *BloomDS) bool {
+	if !b1.Bloom.Union(b2) {
+		return false
+	}
+
+	b1.mu.Lock()
+	defer b1.mu.Unlock()
+
+	b1.exceptions = make(map[string]struct{})
+	return true
+}
+
+// `GetState`: return current State bool
+func (b *BloomException[T]) GetState() BloomDS {
+	return b.Bloom.GetState()
+}
+
+// `Save`: save bloom_ds and the exceptions to dir/id.bloomx
+func (b *BloomException[T]) Save(dir string) error {
+	b.mu.RLock()
+	file := bloomExceptionFile{
+		State:      b.Bloom.GetState(),
+		Exceptions: make([][]byte, 0, len(b.exceptions)),
+	}
+	for key := range b.exceptions {
+		file.Exceptions = append(file.Exceptions, []byte(key))
+	}
+	b.mu.RUnlock()
+
+	return saveGob(dir, file.State.ID+".bloomx", &file)
+}
+
+// `Load`: load bloom_ds and the exceptions from dir/id.bloomx into the wrapped filter,
+// which must have the same parameters, the filter is left untouched if it does not
+func (b *BloomException[T]) Load(dir string) error {
+	state := b.Bloom.GetState()
+	file := bloomExceptionFile{}
+	if err := loadGob(dir, state.ID+".bloomx", &file); err != nil {
+		return err
+	}
+	if !state.compatible(&file.State) {
+		return errors.New("bloom: saved state does not match the wrapped filter")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Bloom.Reset()
+	if !b.Bloom.Union(&file.State) {
+		return errors.New("bloom: saved state does not match the wrapped filter")
+	}
+	b.exceptions = make(map[string]struct{}, len(file.Exceptions))
+	for _, key := range file.Exceptions {
+		b.exceptions[string(key)] = struct{}{}
+	}
+	return nil
+}
+
+// complie-time check
+var _ IBloom = (*BloomException[any])(nil)
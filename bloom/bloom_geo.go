@@ -0,0 +1,241 @@
+package bloom
+
+import (
+	"math"
+	"slices"
+)
+
+const (
+	// geohash alphabet
+	geoBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+	// mean earth radius, in meters
+	geoEarthRadius = 6371000.0
+	// covering cells checked by a single proximity query
+	geoMaxCells = 64
+)
+
+// `BloomGeo`: bloom filter of points stored as their geohash cells at each of Precisions
+// (geohash lengths 1 to 12), a proximity query checks the cells covering the bounding box
+// of its circle at the finest precision that needs at most 64 cells
+type BloomGeo struct {
+	State      BloomDS
+	Precisions []uint64
+	NPoints    uint64
+}
+
+// `NewBloomGeoDefault` return a default `BloomGeo` object with precisions 3 to 7
+// (cells of about 156 km to 150 m)
+func NewBloomGeoDefault(id string, n_bits, n_hash uint64) *BloomGeo {
+	return NewBloomGeoCustom(id, n_bits, n_hash, []uint64{3, 4, 5, 6, 7}, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomGeoCustom` return a custom `BloomGeo` object, precisions outside [1, 12] are ignored
+func NewBloomGeoCustom(id string, n_bits, n_hash uint64, precisions []uint64, seeds [2]uint64) *BloomGeo {
+	valid := make([]uint64, 0, len(precisions))
+	for _, p := range precisions {
+		if p >= 1 && p <= 12 {
+			valid = append(valid, p)
+		}
+	}
+	slices.Sort(valid)
+
+	bloom := BloomGeo{
+		State:      NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		Precisions: slices.Compact(valid),
+	}
+	return &bloom
+}
+
+// `geoBits`: latitude and longitude bits of a geohash precision
+func geoBits(precision uint64) (uint64, uint64) {
+	n := 5 * precision
+	return n / 2, n - n/2
+}
+
+// `geoCell`: latitude and longitude index of the cell of a point
+func geoCell(lat, lon float64, precision uint64) (uint64, uint64) {
+	lat_bits, lon_bits := geoBits(precision)
+	lat_cells := uint64(1) << lat_bits
+	lon_cells := uint64(1) << lon_bits
+
+	y := min(uint64(math.Max((lat+90)/180, 0)*float64(lat_cells)), lat_cells-1)
+	x := min(uint64(math.Max((lon+180)/360, 0)*float64(lon_cells)), lon_cells-1)
+	return y, x
+}
+
+// `geoHash`: geohash of a cell, longitude bits come first
+func geoHash(y, x, precision uint64) string {
+	lat_bits, lon_bits := geoBits(precision)
+
+	out := make([]byte, precision)
+	for c := range out {
+		v := byte(0)
+		for i := 0; i < 5; i++ {
+			bit := uint64(5*c + i)
+			var b uint64
+			if bit%2 == 0 {
+				b = (x >> (lon_bits - 1 - bit/2)) & 1
+			} else {
+				b = (y >> (lat_bits - 1 - bit/2)) & 1
+			}
+			v = v<<1 | byte(b)
+		}
+		out[c] = geoBase32[v]
+	}
+	return string(out)
+}
+
+// `GeoHash`: geohash of a point at a precision (1 to 12 characters)
+func GeoHash(lat, lon float64, precision uint64) string {
+	precision = min(max(precision, 1), 12)
+	y, x := geoCell(lat, lon, precision)
+	return geoHash(y, x, precision)
+}
+
+// `add`: set the bits of a geohash
+func (b *BloomGeo) add(cell string) {
+	for _, index := range b.State.GetIndices(cell) {
+		b.State.Filter[index/64] |= 1 << (index % 64)
+	}
+}
+
+// `check`: check the bits of a geohash
+func (b *BloomGeo) check(cell string) bool {
+	for _, index := range b.State.GetIndices(cell) {
+		if b.State.Filter[index/64]&(1<<(index%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// `Add`: add a point to the set
+func (b *BloomGeo) Add(lat, lon float64) {
+	for _, p := range b.Precisions {
+		b.add(GeoHash(lat, lon, p))
+	}
+	b.NPoints++
+}
+
+// `Check`: check if a point in the cell of (lat, lon) at the finest precision may be in
+// the set (false negative: never, false positives: maybe)
+func (b *BloomGeo) Check(lat, lon float64) bool {
+	if len(b.Precisions) == 0 {
+		return true
+	}
+	return b.check(GeoHash(lat, lon, b.Precisions[len(b.Precisions)-1]))
+}
+
+// `covering`: geohashes of the cells covering the bounding box of a circle at a precision,
+// nil if more than geoMaxCells are needed
+func (b *BloomGeo) covering(lat, lon, radius float64, precision uint64) []string {
+	dlat := radius / geoEarthRadius * 180 / math.Pi
+	min_lat := math.Max(lat-dlat, -90)
+	max_lat := math.Min(lat+dlat, 90)
+
+	// the circle spans every longitude if it reaches a pole
+	min_lon, max_lon := -180.0, 180.0
+	if min_lat > -90 && max_lat < 90 {
+		dlon := dlat / math.Cos(math.Max(math.Abs(min_lat), math.Abs(max_lat))*math.Pi/180)
+		if dlon < 180 {
+			min_lon, max_lon = lon-dlon, lon+dlon
+		}
+	}
+
+	_, lon_bits := geoBits(precision)
+	lon_cells := uint64(1) << lon_bits
+	y0, x0 := geoCell(min_lat, min_lon, precision)
+	y1, x1 := geoCell(max_lat, max_lon, precision)
+
+	// the box may wrap around the antimeridian
+	n_x := uint64(0)
+	switch {
+	case max_lon-min_lon >= 360:
+		x0, n_x = 0, lon_cells
+	case min_lon < -180:
+		_, x0 = geoCell(0, min_lon+360, precision)
+		n_x = lon_cells - x0 + x1 + 1
+	case max_lon > 180:
+		_, x1 = geoCell(0, max_lon-360, precision)
+		n_x = lon_cells - x0 + x1 + 1
+	default:
+		n_x = x1 - x0 + 1
+	}
+	if (y1-y0+1)*n_x > geoMaxCells {
+		return nil
+	}
+
+	cells := make([]string, 0, (y1-y0+1)*n_x)
+	for y := y0; y <= y1; y++ {
+		for i := uint64(0); i < n_x; i++ {
+			cells = append(cells, geoHash(y, (x0+i)%lon_cells, precision))
+		}
+	}
+	return cells
+}
+
+// `MayContainNear`: check if a point within radius meters of (lat, lon) may be in the set
+// (false negative: never, false positives: maybe, including points of the covering cells
+// farther than radius), always true if even the coarsest precision needs too many cells
+func (b *BloomGeo) MayContainNear(lat, lon, radius float64) bool {
+	for i := len(b.Precisions) - 1; i >= 0; i-- {
+		cells := b.covering(lat, lon, radius, b.Precisions[i])
+		if cells == nil {
+			continue
+		}
+		for _, cell := range cells {
+			if b.check(cell) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// `Reset`: resets bloom_ds and the point count
+func (b *BloomGeo) Reset() {
+	b.State.Reset()
+	b.NPoints = 0
+}
+
+// `GetState`: return current State bool
+func (b *BloomGeo) GetState() BloomDS {
+	return b.State
+}
+
+// `GetFalsePositiveProbabilityEstimates`: false positive rate of a `MayContainNear` query
+// answered at each of Precisions, 1 where a precision needs too many cells
+func (b *BloomGeo) GetFalsePositiveProbabilityEstimates(lat, lon, radius float64) []float64 {
+	// every point adds one entry per precision
+	p := GetFalsePositiveProbabilityEstimate(b.State.NBits, b.State.NHash, b.NPoints*uint64(len(b.Precisions)))
+
+	estimates := make([]float64, len(b.Precisions))
+	for i, precision := range b.Precisions {
+		cells := b.covering(lat, lon, radius, precision)
+		if cells == nil {
+			estimates[i] = 1
+			continue
+		}
+		estimates[i] = 1 - math.Pow(1-p, float64(len(cells)))
+	}
+	return estimates
+}
+
+// `Save`: save bloom_ds and the precisions to dir/id.geo
+func (b *BloomGeo) Save(dir string) error {
+	return saveGob(dir, b.State.ID+".geo", b)
+}
+
+// `Load`: load bloom_ds and the precisions from dir/id.geo
+func (b *BloomGeo) Load(dir string) error {
+	loaded := BloomGeo{}
+	if err := loadGob(dir, b.State.ID+".geo", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.State.loadHasher(); err != nil {
+		return err
+	}
+	*b = loaded
+	return nil
+}
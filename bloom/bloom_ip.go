@@ -0,0 +1,205 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"net/netip"
+	"slices"
+)
+
+// a single prefix may expand to at most 2^ipMaxExpansionBits entries
+const ipMaxExpansionBits = 16
+
+// `BloomIP`: bloom filter of ip addresses and cidr prefixes, a prefix is stored at the
+// configured prefix lengths of its family (V4Lengths, V6Lengths), a shorter prefix is
+// expanded to the next configured length, and an address is checked at every length
+type BloomIP struct {
+	State     BloomDS
+	V4Lengths []uint64
+	V6Lengths []uint64
+	NEntries  uint64
+}
+
+// `NewBloomIPDefault` return a default `BloomIP` object with ipv4 lengths every 4 bits
+// and ipv6 lengths every 8 bits
+func NewBloomIPDefault(id string, n_bits, n_hash uint64) *BloomIP {
+	v4 := make([]uint64, 0, 8)
+	for l := uint64(4); l <= 32; l += 4 {
+		v4 = append(v4, l)
+	}
+	v6 := make([]uint64, 0, 16)
+	for l := uint64(8); l <= 128; l += 8 {
+		v6 = append(v6, l)
+	}
+	return NewBloomIPCustom(id, n_bits, n_hash, v4, v6, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomIPCustom` return a custom `BloomIP` object, the full lengths 32 and 128 are
+// always included so single addresses are stored exactly
+func NewBloomIPCustom(id string, n_bits, n_hash uint64, v4_lengths, v6_lengths []uint64, seeds [2]uint64) *BloomIP {
+	bloom := BloomIP{
+		State:     NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		V4Lengths: ipLengths(v4_lengths, 32),
+		V6Lengths: ipLengths(v6_lengths, 128),
+	}
+	return &bloom
+}
+
+// `ipLengths`: sorted distinct lengths in [1, full], including full
+func ipLengths(lengths []uint64, full uint64) []uint64 {
+	out := make([]uint64, 0, len(lengths)+1)
+	for _, l := range lengths {
+		if l >= 1 && l <= full {
+			out = append(out, l)
+		}
+	}
+	out = append(out, full)
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// `lengths`: configured lengths of the family of an address
+func (b *BloomIP) lengths(addr netip.Addr) []uint64 {
+	if addr.Is4() {
+		return b.V4Lengths
+	}
+	return b.V6Lengths
+}
+
+// `getIndices`: indices of a masked prefix
+func (b *BloomIP) getIndices(prefix netip.Prefix) []uint64 {
+	data := append([]byte{byte(prefix.Bits())}, toBytes(prefix.Addr())...)
+	return b.State.getIndices(data)
+}
+
+// `Add`: add an address to the set
+func (b *BloomIP) Add(addr netip.Addr) {
+	addr = addr.Unmap().WithZone("")
+	b.AddPrefix(netip.PrefixFrom(addr, addr.BitLen()))
+}
+
+// `AddPrefix`: add every address of a prefix to the set, false if the prefix is invalid or
+// would expand to more than 65536 entries
+func (b *BloomIP) AddPrefix(prefix netip.Prefix) bool {
+	if !prefix.IsValid() {
+		return false
+	}
+	addr := prefix.Addr().Unmap().WithZone("")
+	n := uint64(prefix.Bits())
+	if prefix.Addr().Is4In6() {
+		n = max(n, 96) - 96
+	}
+
+	// the first configured length that is at least the prefix length
+	lengths := b.lengths(addr)
+	i, _ := slices.BinarySearch(lengths, n)
+	l := lengths[i]
+	if l-n > ipMaxExpansionBits {
+		return false
+	}
+
+	// expand to all sub-prefixes of length l
+	base := netip.PrefixFrom(addr, int(n)).Masked().Addr()
+	sub := netip.PrefixFrom(base, int(l))
+	for range uint64(1) << (l - n) {
+		for _, index := range b.getIndices(sub) {
+			b.State.Filter[index/64] |= 1 << (index % 64)
+		}
+		b.NEntries++
+		sub = ipNextPrefix(sub)
+	}
+	return true
+}
+
+// `ipNextPrefix`: the prefix of the same length right after p
+func ipNextPrefix(p netip.Prefix) netip.Prefix {
+	a := p.Addr().As16()
+	hi := binary.BigEndian.Uint64(a[:8])
+	lo := binary.BigEndian.Uint64(a[8:])
+
+	// add 1 at the last bit of the prefix, as a 128-bit integer
+	shift := 128 - p.Bits()
+	if p.Addr().Is4() {
+		shift = 32 - p.Bits()
+	}
+	if shift >= 64 {
+		hi += 1 << (shift - 64)
+	} else {
+		var carry uint64
+		lo, carry = bits.Add64(lo, 1<<shift, 0)
+		hi += carry
+	}
+	binary.BigEndian.PutUint64(a[:8], hi)
+	binary.BigEndian.PutUint64(a[8:], lo)
+
+	next := netip.AddrFrom16(a)
+	if p.Addr().Is4() {
+		next = next.Unmap()
+	}
+	return netip.PrefixFrom(next, p.Bits())
+}
+
+// `Check`: check if an address is covered by the set (false negative: never, false
+// positives: maybe)
+func (b *BloomIP) Check(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	if !addr.IsValid() {
+		return false
+	}
+
+	for _, l := range b.lengths(addr) {
+		prefix, _ := addr.Prefix(int(l))
+		found := true
+		for _, index := range b.getIndices(prefix) {
+			if b.State.Filter[index/64]&(1<<(index%64)) == 0 {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// `Reset`: resets bloom_ds and the entry count
+func (b *BloomIP) Reset() {
+	b.State.Reset()
+	b.NEntries = 0
+}
+
+// `GetState`: return current State bool
+func (b *BloomIP) GetState() BloomDS {
+	return b.State
+}
+
+// `GetFalsePositiveProbabilityEstimate`: false positive rate of a `Check` of an ipv4
+// (v6 false) or ipv6 (v6 true) address, each configured length can be a false positive
+func (b *BloomIP) GetFalsePositiveProbabilityEstimate(v6 bool) float64 {
+	lengths := b.V4Lengths
+	if v6 {
+		lengths = b.V6Lengths
+	}
+	p := GetFalsePositiveProbabilityEstimate(b.State.NBits, b.State.NHash, b.NEntries)
+	return 1 - math.Pow(1-p, float64(len(lengths)))
+}
+
+// `Save`: save bloom_ds and the prefix lengths to dir/id.ip
+func (b *BloomIP) Save(dir string) error {
+	return saveGob(dir, b.State.ID+".ip", b)
+}
+
+// `Load`: load bloom_ds and the prefix lengths from dir/id.ip
+func (b *BloomIP) Load(dir string) error {
+	loaded := BloomIP{}
+	if err := loadGob(dir, b.State.ID+".ip", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.State.loadHasher(); err != nil {
+		return err
+	}
+	*b = loaded
+	return nil
+}
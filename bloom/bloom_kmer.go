@@ -0,0 +1,240 @@
+package bloom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// longest line of a FASTA/FASTQ stream
+const kmerMaxLine = 1 << 28
+
+// `BloomKmer`: bloom filter of canonical k-mers of DNA sequences, a k-mer (K up to 32 bases)
+// is packed 2 bits per base and stored as the smaller of itself and its reverse complement,
+// bases other than ACGT (either case) break the k-mers spanning them
+type BloomKmer struct {
+	State  BloomDS
+	K      uint64
+	NKmers uint64
+}
+
+// `NewBloomKmerDefault` return a default `BloomKmer` object
+func NewBloomKmerDefault(id string, n_bits, n_hash, k uint64) *BloomKmer {
+	return NewBloomKmerCustom(id, n_bits, n_hash, k, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomKmerCustom` return a custom `BloomKmer` object
+func NewBloomKmerCustom(id string, n_bits, n_hash, k uint64, seeds [2]uint64) *BloomKmer {
+
+	if k < 1 || k > 32 {
+		fmt.Println("NewBloomKmerCustom: k not in [1,32], using default 31")
+		k = 31
+	}
+
+	bloom := BloomKmer{
+		State: NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		K:     k,
+	}
+	return &bloom
+}
+
+// `kmerBase`: 2-bit code of a base, false if it is not one of ACGT
+func kmerBase(c byte) (uint64, bool) {
+	switch c {
+	case 'A', 'a':
+		return 0, true
+	case 'C', 'c':
+		return 1, true
+	case 'G', 'g':
+		return 2, true
+	case 'T', 't':
+		return 3, true
+	}
+	return 0, false
+}
+
+// `kmerScanner`: rolling forward and reverse complement k-mers of a stream of bases
+type kmerScanner struct {
+	k   uint64
+	fwd uint64
+	rev uint64
+	n   uint64 // valid bases in the window
+}
+
+// `feed`: push a base, return the canonical k-mer ending at it if the window is full
+func (s *kmerScanner) feed(c byte) (uint64, bool) {
+	code, ok := kmerBase(c)
+	if !ok {
+		s.n = 0
+		return 0, false
+	}
+
+	mask := ^uint64(0) >> (64 - 2*s.k)
+	s.fwd = (s.fwd<<2 | code) & mask
+	s.rev = s.rev>>2 | (3-code)<<(2*(s.k-1))
+	s.n = min(s.n+1, s.k)
+	if s.n < s.k {
+		return 0, false
+	}
+	return min(s.fwd, s.rev), true
+}
+
+// `kmers`: call fn with every canonical k-mer of a sequence
+func (b *BloomKmer) kmers(seq []byte, fn func(uint64)) {
+	s := kmerScanner{k: b.K}
+	for _, c := range seq {
+		if kmer, ok := s.feed(c); ok {
+			fn(kmer)
+		}
+	}
+}
+
+// `getIndices`: indices of a packed k-mer
+func (b *BloomKmer) getIndices(kmer uint64) []uint64 {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], kmer)
+	return b.State.getIndices(data[:])
+}
+
+// `add`: set the bits of a packed k-mer
+func (b *BloomKmer) add(kmer uint64) {
+	for _, index := range b.getIndices(kmer) {
+		b.State.Filter[index/64] |= 1 << (index % 64)
+	}
+	b.NKmers++
+}
+
+// `check`: check the bits of a packed k-mer
+func (b *BloomKmer) check(kmer uint64) bool {
+	for _, index := range b.getIndices(kmer) {
+		if b.State.Filter[index/64]&(1<<(index%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// `AddSequence`: add the k-mers of a sequence, return the number of k-mers added
+func (b *BloomKmer) AddSequence(seq []byte) uint64 {
+	before := b.NKmers
+	b.kmers(seq, b.add)
+	return b.NKmers - before
+}
+
+// `AddReads`: add the k-mers of every record of a FASTA (">" headers, sequences may span
+// lines) or FASTQ ("@" header, sequence, "+", quality) stream, return the number of records
+func (b *BloomKmer) AddReads(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), kmerMaxLine)
+
+	n_records := uint64(0)
+	s := kmerScanner{k: b.K}
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		switch line[0] {
+		case '>':
+			// fasta record, the sequence lines follow
+			n_records++
+			s = kmerScanner{k: b.K}
+
+		case '@':
+			// fastq record, one sequence line then the separator and quality lines
+			n_records++
+			s = kmerScanner{k: b.K}
+			if !scanner.Scan() {
+				return n_records, fmt.Errorf("bloom: fastq record %d has no sequence", n_records)
+			}
+			for _, c := range scanner.Bytes() {
+				if kmer, ok := s.feed(c); ok {
+					b.add(kmer)
+				}
+			}
+			if !scanner.Scan() || !scanner.Scan() {
+				return n_records, fmt.Errorf("bloom: fastq record %d has no quality", n_records)
+			}
+
+		default:
+			// fasta sequence line, k-mers span the line breaks
+			for _, c := range line {
+				if c == '\r' {
+					continue
+				}
+				if kmer, ok := s.feed(c); ok {
+					b.add(kmer)
+				}
+			}
+		}
+	}
+
+	return n_records, scanner.Err()
+}
+
+// `CheckKmer`: check a k-mer of K bases to the set (false negative: never, false
+// positives: maybe), false if it is not K valid bases
+func (b *BloomKmer) CheckKmer(kmer string) bool {
+	if uint64(len(kmer)) != b.K {
+		return false
+	}
+	found := false
+	b.kmers([]byte(kmer), func(k uint64) {
+		found = b.check(k)
+	})
+	return found
+}
+
+// `QueryRead`: fraction of the k-mers of a read that may be in the set, 0 if the read has
+// no k-mer
+func (b *BloomKmer) QueryRead(read []byte) float64 {
+	n_total, n_found := 0, 0
+	b.kmers(read, func(kmer uint64) {
+		n_total++
+		if b.check(kmer) {
+			n_found++
+		}
+	})
+
+	if n_total == 0 {
+		return 0
+	}
+	return float64(n_found) / float64(n_total)
+}
+
+// `Reset`: resets bloom_ds and the k-mer count
+func (b *BloomKmer) Reset() {
+	b.State.Reset()
+	b.NKmers = 0
+}
+
+// `GetState`: return current State bool
+func (b *BloomKmer) GetState() BloomDS {
+	return b.State
+}
+
+// `GetFalsePositiveProbabilityEstimate`: false positive rate of a k-mer, counting repeated
+// k-mers as distinct so it is an upper bound
+func (b *BloomKmer) GetFalsePositiveProbabilityEstimate() float64 {
+	return GetFalsePositiveProbabilityEstimate(b.State.NBits, b.State.NHash, b.NKmers)
+}
+
+// `Save`: save bloom_ds and k to dir/id.kmer
+func (b *BloomKmer) Save(dir string) error {
+	return saveGob(dir, b.State.ID+".kmer", b)
+}
+
+// `Load`: load bloom_ds and k from dir/id.kmer
+func (b *BloomKmer) Load(dir string) error {
+	loaded := BloomKmer{}
+	if err := loadGob(dir, b.State.ID+".kmer", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.State.loadHasher(); err != nil {
+		return err
+	}
+	*b = loaded
+	return nil
+}
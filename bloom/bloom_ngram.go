@@ -0,0 +1,123 @@
+package bloom
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// `BloomNGram`: bloom filter over the character n-grams (N runes) of the strings added,
+// a string can only contain a substring if the filter holds all its n-grams, with
+// FoldCase set both sides are lower cased
+type BloomNGram struct {
+	State    BloomDS
+	N        uint64
+	FoldCase bool
+}
+
+// `NewBloomNGramDefault` return a default `BloomNGram` object
+func NewBloomNGramDefault(id string, n_bits, n_hash, n uint64, fold_case bool) *BloomNGram {
+	return NewBloomNGramCustom(id, n_bits, n_hash, n, fold_case, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomNGramCustom` return a custom `BloomNGram` object
+func NewBloomNGramCustom(id string, n_bits, n_hash, n uint64, fold_case bool, seeds [2]uint64) *BloomNGram {
+
+	if n == 0 {
+		fmt.Println("NewBloomNGramCustom: n is 0, using default 3")
+		n = 3
+	}
+
+	bloom := BloomNGram{
+		State:    NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		N:        n,
+		FoldCase: fold_case,
+	}
+	return &bloom
+}
+
+// `grams`: the n-grams of a string, none if it is shorter than N runes
+func (b *BloomNGram) grams(s string) []string {
+	if b.FoldCase {
+		s = strings.ToLower(s)
+	}
+
+	// byte offset of every rune, and of the end of the string
+	offsets := make([]int, 0, len(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(s))
+
+	grams := make([]string, 0)
+	for i := 0; i+int(b.N) < len(offsets); i++ {
+		grams = append(grams, s[offsets[i]:offsets[i+int(b.N)]])
+	}
+	return grams
+}
+
+// `Add`: add the n-grams of a string to the set
+func (b *BloomNGram) Add(s string) {
+	for _, gram := range b.grams(s) {
+		// find word index and offset, and set it to true
+		for _, index := range b.State.GetIndices(gram) {
+			b.State.Filter[index/64] |= 1 << (index % 64)
+		}
+	}
+}
+
+// `MayContainSubstring`: check if an added string may contain s (false negative: never,
+// false positives: maybe), always true if s is shorter than N runes
+func (b *BloomNGram) MayContainSubstring(s string) bool {
+	for _, gram := range b.grams(s) {
+		for _, index := range b.State.GetIndices(gram) {
+			if b.State.Filter[index/64]&(1<<(index%64)) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// `Reset`: resets bloom_ds
+func (b *BloomNGram) Reset() {
+	b.State.Reset()
+}
+
+// `Union`: tries state union
+func (b1 *BloomNGram) Union(b2 *BloomDS) bool {
+	return b1.State.Union(b2)
+}
+
+// `GetState`: return current State bool
+func (b *BloomNGram) GetState() BloomDS {
+	return b.State
+}
+
+// `GetFalsePositiveProbabilityEstimate`: false positive rate of a query of query_runes runes
+// after n_grams n-grams were added, assuming its n-grams fail independently
+func (b *BloomNGram) GetFalsePositiveProbabilityEstimate(n_grams, query_runes uint64) float64 {
+	if query_runes < b.N {
+		return 1
+	}
+	p := GetFalsePositiveProbabilityEstimate(b.State.NBits, b.State.NHash, n_grams)
+	return math.Pow(p, float64(query_runes-b.N+1))
+}
+
+// `Save`: save bloom_ds and the n-gram parameters to dir/id.ngram
+func (b *BloomNGram) Save(dir string) error {
+	return saveGob(dir, b.State.ID+".ngram", b)
+}
+
+// `Load`: load bloom_ds and the n-gram parameters from dir/id.ngram
+func (b *BloomNGram) Load(dir string) error {
+	loaded := BloomNGram{}
+	if err := loadGob(dir, b.State.ID+".ngram", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.State.loadHasher(); err != nil {
+		return err
+	}
+	*b = loaded
+	return nil
+}
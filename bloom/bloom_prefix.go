@@ -0,0 +1,148 @@
+package bloom
+
+import (
+	"slices"
+)
+
+// tags keeping full keys and prefixes apart in the filter
+const (
+	prefixTagKey    byte = 0
+	prefixTagPrefix byte = 1
+)
+
+// `BloomPrefix`: bloom filter answering prefix queries, every key is added as a full key
+// and as each of its prefixes whose length is in Lengths or a multiple of Step (0 for
+// none), NEntries counts all entries added so the false positive rate reflects them
+type BloomPrefix struct {
+	State    BloomDS
+	Lengths  []uint64
+	Step     uint64
+	NEntries uint64
+}
+
+// `NewBloomPrefixDefault` return a default `BloomPrefix` object
+func NewBloomPrefixDefault(id string, n_bits, n_hash uint64, lengths []uint64, step uint64) *BloomPrefix {
+	return NewBloomPrefixCustom(id, n_bits, n_hash, lengths, step, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomPrefixCustom` return a custom `BloomPrefix` object
+func NewBloomPrefixCustom(id string, n_bits, n_hash uint64, lengths []uint64, step uint64, seeds [2]uint64) *BloomPrefix {
+	lengths = slices.Clone(lengths)
+	slices.Sort(lengths)
+
+	bloom := BloomPrefix{
+		State:   NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		Lengths: slices.Compact(lengths),
+		Step:    step,
+	}
+	return &bloom
+}
+
+// `isPrefixLength`: true if prefixes of length n are added
+func (b *BloomPrefix) isPrefixLength(n uint64) bool {
+	if n == 0 {
+		return false
+	}
+	if b.Step > 0 && n%b.Step == 0 {
+		return true
+	}
+	_, found := slices.BinarySearch(b.Lengths, n)
+	return found
+}
+
+// `getIndices`: indices of a tagged entry
+func (b *BloomPrefix) getIndices(tag byte, s string) []uint64 {
+	data := append([]byte{tag}, s...)
+	return b.State.getIndices(data)
+}
+
+// `add`: set the bits of a tagged entry
+func (b *BloomPrefix) add(tag byte, s string) {
+	for _, index := range b.getIndices(tag, s) {
+		b.State.Filter[index/64] |= 1 << (index % 64)
+	}
+	b.NEntries++
+}
+
+// `check`: check the bits of a tagged entry
+func (b *BloomPrefix) check(tag byte, s string) bool {
+	for _, index := range b.getIndices(tag, s) {
+		if b.State.Filter[index/64]&(1<<(index%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// `Add`: add a key and its configured prefixes to the set
+func (b *BloomPrefix) Add(key string) {
+	b.add(prefixTagKey, key)
+	for n := uint64(1); n <= uint64(len(key)); n++ {
+		if b.isPrefixLength(n) {
+			b.add(prefixTagPrefix, key[:n])
+		}
+	}
+}
+
+// `Check`: check a key to the set (false negative: never, false positives: maybe)
+func (b *BloomPrefix) Check(key string) bool {
+	return b.check(prefixTagKey, key)
+}
+
+// `MayContainPrefix`: check if a key starting with prefix may be in the set (false
+// negative: never, false positives: maybe), a prefix of an unconfigured length is checked
+// through its longest configured prefix, and is always true if there is none
+func (b *BloomPrefix) MayContainPrefix(prefix string) bool {
+	for n := uint64(len(prefix)); n > 0; n-- {
+		if b.isPrefixLength(n) {
+			return b.check(prefixTagPrefix, prefix[:n])
+		}
+	}
+	return true
+}
+
+// `Reset`: resets bloom_ds and the entry count
+func (b *BloomPrefix) Reset() {
+	b.State.Reset()
+	b.NEntries = 0
+}
+
+// `GetState`: return current State bool
+func (b *BloomPrefix) GetState() BloomDS {
+	return b.State
+}
+
+// `GetEntriesPerKey`: number of entries added for a key of key_len bytes
+func (b *BloomPrefix) GetEntriesPerKey(key_len uint64) uint64 {
+	entries := uint64(1)
+	for n := uint64(1); n <= key_len; n++ {
+		if b.isPrefixLength(n) {
+			entries++
+		}
+	}
+	return entries
+}
+
+// `GetFalsePositiveProbabilityEstimate`: false positive rate of a key or prefix check,
+// counting the prefix entries along with the keys
+func (b *BloomPrefix) GetFalsePositiveProbabilityEstimate() float64 {
+	return GetFalsePositiveProbabilityEstimate(b.State.NBits, b.State.NHash, b.NEntries)
+}
+
+// `Save`: save bloom_ds and the prefix parameters to dir/id.prefix
+func (b *BloomPrefix) Save(dir string) error {
+	return saveGob(dir, b.State.ID+".prefix", b)
+}
+
+// `Load`: load bloom_ds and the prefix parameters from dir/id.prefix
+func (b *BloomPrefix) Load(dir string) error {
+	loaded := BloomPrefix{}
+	if err := loadGob(dir, b.State.ID+".prefix", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.State.loadHasher(); err != nil {
+		return err
+	}
+	*b = loaded
+	return nil
+}
@@ -0,0 +1,167 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// `BloomRange`: range filter for uint64 keys over dyadic intervals, a key is added at every
+// level l in [0, Levels] as its prefix key>>l, a range is covered by dyadic intervals and
+// each one is confirmed by descending to a key at level 0, ranges wider than MaxWidth
+// are not covered and always reported as possibly non-empty
+type BloomRange struct {
+	State    BloomDS
+	Levels   uint64
+	MaxWidth uint64
+}
+
+// `NewBloomRangeDefault` return a default `BloomRange` object
+func NewBloomRangeDefault(id string, n_bits, n_hash, max_width uint64) *BloomRange {
+	return NewBloomRangeCustom(id, n_bits, n_hash, max_width, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomRangeCustom` return a custom `BloomRange` object
+func NewBloomRangeCustom(id string, n_bits, n_hash, max_width uint64, seeds [2]uint64) *BloomRange {
+
+	if max_width == 0 {
+		fmt.Println("NewBloomRangeCustom: max_width is 0, using default 1024")
+		max_width = 1024
+	}
+
+	bloom := BloomRange{
+		State:    NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		Levels:   min(uint64(bits.Len64(max_width-1)), 63),
+		MaxWidth: max_width,
+	}
+	return &bloom
+}
+
+// `getIndices`: indices of the dyadic interval of a level and prefix
+func (b *BloomRange) getIndices(level, prefix uint64) []uint64 {
+	var data [9]byte
+	data[0] = byte(level)
+	binary.BigEndian.PutUint64(data[1:], prefix)
+	return b.State.getIndices(data[:])
+}
+
+// `check`: check the bits of a dyadic interval
+func (b *BloomRange) check(level, prefix uint64) bool {
+	for _, index := range b.getIndices(level, prefix) {
+		if b.State.Filter[index/64]&(1<<(index%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// `descend`: check a dyadic interval and one of its children down to a key
+func (b *BloomRange) descend(level, prefix uint64) bool {
+	if !b.check(level, prefix) {
+		return false
+	}
+	if level == 0 {
+		return true
+	}
+	return b.descend(level-1, prefix<<1) || b.descend(level-1, prefix<<1|1)
+}
+
+// `AddUint64`: add a key to the set
+func (b *BloomRange) AddUint64(key uint64) {
+	for level := uint64(0); level <= b.Levels; level++ {
+		for _, index := range b.getIndices(level, key>>level) {
+			b.State.Filter[index/64] |= 1 << (index % 64)
+		}
+	}
+}
+
+// `CheckUint64`: check a key to the set (false negative: never, false positives: maybe)
+func (b *BloomRange) CheckUint64(key uint64) bool {
+	return b.check(0, key)
+}
+
+// `MayContainRange`: check if a key in [lo, hi] may be in the set (false negative: never,
+// false positives: maybe), always true for ranges wider than MaxWidth
+func (b *BloomRange) MayContainRange(lo, hi uint64) bool {
+	if lo > hi {
+		return false
+	}
+	if hi-lo >= b.MaxWidth {
+		return true
+	}
+
+	// cover [lo, hi] with the largest aligned dyadic intervals
+	for x := lo; ; {
+		level := min(uint64(bits.TrailingZeros64(x)), b.Levels)
+		for level > 0 && hi-x < uint64(1)<<level-1 {
+			level--
+		}
+		if b.descend(level, x>>level) {
+			return true
+		}
+
+		last := x + (uint64(1)<<level - 1)
+		if last >= hi {
+			return false
+		}
+		x = last + 1
+	}
+}
+
+// `Reset`: resets bloom_ds
+func (b *BloomRange) Reset() {
+	b.State.Reset()
+}
+
+// `GetState`: return current State bool
+func (b *BloomRange) GetState() BloomDS {
+	return b.State
+}
+
+// `GetFalsePositiveProbabilityEstimate`: estimated false positive rate of an empty range of
+// width keys after n_add keys were added
+func (b *BloomRange) GetFalsePositiveProbabilityEstimate(n_add, width uint64) float64 {
+	if width > b.MaxWidth {
+		return 1
+	}
+	if width == 0 {
+		return 0
+	}
+
+	// every interval adds Levels+1 entries
+	p := GetFalsePositiveProbabilityEstimate(b.State.NBits, b.State.NHash, n_add*(b.Levels+1))
+
+	// an empty interval at level l passes if it and one of its children pass
+	q := make([]float64, b.Levels+1)
+	q[0] = p
+	for l := uint64(1); l <= b.Levels; l++ {
+		q[l] = p * (1 - (1-q[l-1])*(1-q[l-1]))
+	}
+
+	// the cover of an unaligned range has up to 2 intervals per level below width
+	pass := 1.0
+	top := min(uint64(bits.Len64(width))-1, b.Levels)
+	for l := uint64(0); l <= top; l++ {
+		pass *= math.Pow(1-q[l], 2)
+	}
+	return 1 - pass
+}
+
+// `Save`: save bloom_ds and the range parameters to dir/id.range
+func (b *BloomRange) Save(dir string) error {
+	return saveGob(dir, b.State.ID+".range", b)
+}
+
+// `Load`: load bloom_ds and the range parameters from dir/id.range
+func (b *BloomRange) Load(dir string) error {
+	loaded := BloomRange{}
+	if err := loadGob(dir, b.State.ID+".range", &loaded); err != nil {
+		return err
+	}
+	if err := loaded.State.loadHasher(); err != nil {
+		return err
+	}
+	*b = loaded
+	return nil
+}
@@ -0,0 +1,230 @@
+package bloom
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// `BloomRotating`: time windowed bloom filter made of NGenerations generations,
+// a new generation starts every Interval and the oldest one is dropped, so a value
+// stays visible for between (NGenerations-1)*Interval and NGenerations*Interval
+type BloomRotating[T any] struct {
+	ID           string
+	NBits        uint64
+	NHash        uint64
+	NGenerations uint64
+	Seeds        [2]uint64
+	Interval     time.Duration
+	Clock        func() time.Time
+
+	state   atomic.Pointer[rotatingState]
+	encoder KeyEncoder[T]
+}
+
+// `rotatingState`: immutable snapshot of the live generations
+type rotatingState struct {
+	gens  []*BloomDS // newest first
+	epoch time.Time  // start of the newest generation
+}
+
+// `NewBloomRotatingDefault` return a default `BloomRotating` object
+func NewBloomRotatingDefault(id string, n_bits, n_hash, n_gens uint64, interval time.Duration) *BloomRotating[any] {
+	return NewBloomRotatingCustom(id, n_bits, n_hash, n_gens, interval, [2]uint64{DefaultSeed1, DefaultSeed2}, time.Now)
+}
+
+// `NewBloomRotatingCustom` return a custom `BloomRotating` object, clock defaults to time.Now
+func NewBloomRotatingCustom(id string, n_bits, n_hash, n_gens uint64, interval time.Duration, seeds [2]uint64, clock func() time.Time) *BloomRotating[any] {
+	return NewBloomRotatingTypedCustom[any](id, n_bits, n_hash, n_gens, interval, seeds, clock, AnyEncoder{})
+}
+
+// `NewBloomRotatingTypedDefault` return a default `BloomRotating` object for values encoded by
+// encoder
+func NewBloomRotatingTypedDefault[T any](id string, n_bits, n_hash, n_gens uint64, interval time.Duration, encoder KeyEncoder[T]) *BloomRotating[T] {
+	return NewBloomRotatingTypedCustom(id, n_bits, n_hash, n_gens, interval, [2]uint64{DefaultSeed1, DefaultSeed2}, time.Now, encoder)
+}
+
+// `NewBloomRotatingTypedCustom` return a custom `BloomRotating` object for values encoded by
+// encoder, clock defaults to time.Now
+func NewBloomRotatingTypedCustom[T any](id string, n_bits, n_hash, n_gens uint64, interval time.Duration, seeds [2]uint64, clock func() time.Time, encoder KeyEncoder[T]) *BloomRotating[T] {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	bloom := BloomRotating[T]{
+		ID:           id,
+		NBits:        n_bits,
+		NHash:        n_hash,
+		NGenerations: max(n_gens, 1),
+		Seeds:        seeds,
+		Interval:     interval,
+		Clock:        clock,
+		encoder:      encoder,
+	}
+	bloom.Reset()
+	return &bloom
+}
+
+// `newGeneration`: return an empty generation
+func (b *BloomRotating[T]) newGeneration() *BloomDS {
+	bds := NewBloomDSCustom(b.ID, b.NBits, b.NHash, b.Seeds)
+	return &bds
+}
+
+// `current`: return the live generations, rotating first if intervals have elapsed
+func (b *BloomRotating[T]) current() *rotatingState {
+	for {
+		s := b.state.Load()
+		now := b.Clock()
+
+		if b.Interval <= 0 || now.Sub(s.epoch) < b.Interval {
+			return s
+		}
+
+		// number of generations that should have started since the epoch
+		steps := uint64(now.Sub(s.epoch) / b.Interval)
+		fresh := min(steps, b.NGenerations)
+
+		gens := make([]*BloomDS, 0, b.NGenerations)
+		for i := uint64(0); i < fresh; i++ {
+			gens = append(gens, b.newGeneration())
+		}
+		gens = append(gens, s.gens[:b.NGenerations-fresh]...)
+
+		next := &rotatingState{
+			gens:  gens,
+			epoch: s.epoch.Add(time.Duration(steps) * b.Interval),
+		}
+
+		// lost the race against another rotation, retry with its state
+		if b.state.CompareAndSwap(s, next) {
+			return next
+		}
+	}
+}
+
+// `Add`: add a value to the current generation
+func (b *BloomRotating[T]) Add(value T) {
+	b.add(keyIndices(b.encoder, value, b.NBits, b.NHash, b.Seeds))
+}
+
+// `AddE`: add a value to the current generation, an error wrapping `ErrEncodeFailed` if it
+// cannot be encoded
+func (b *BloomRotating[T]) AddE(value T) error {
+	indices, err := keyIndicesE(b.encoder, value, b.NBits, b.NHash, b.Seeds)
+	if err != nil {
+		return err
+	}
+	b.add(indices)
+	return nil
+}
+
+// `add`: set the indices in the current generation
+func (b *BloomRotating[T]) add(indices []uint64) {
+	s := b.current()
+	s.gens[0].addAtomic(indices)
+}
+
+// `Check`: check a value against all live generations (false negative: never within the window, false positives: maybe)
+func (b *BloomRotating[T]) Check(value T) bool {
+	return b.check(keyIndices(b.encoder, value, b.NBits, b.NHash, b.Seeds))
+}
+
+// `CheckE`: check a value like `Check`, an error wrapping `ErrEncodeFailed` if it cannot be
+// encoded
+func (b *BloomRotating[T]) CheckE(value T) (bool, error) {
+	indices, err := keyIndicesE(b.encoder, value, b.NBits, b.NHash, b.Seeds)
+	if err != nil {
+		return false, err
+	}
+	return b.check(indices), nil
+}
+
+// `check`: check the indices against all live generations
+func (b *BloomRotating[T]) check(indices []uint64) bool {
+	s := b.current()
+
+	for _, gen := range s.gens {
+		if gen.checkAtomic(indices) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// `Rotate`: start a new generation now, dropping the oldest one
+func (b *BloomRotating[T]) Rotate() {
+	for {
+		s := b.state.Load()
+
+		gens := make([]*BloomDS, 0, b.NGenerations)
+		gens = append(gens, b.newGeneration())
+		gens = append(gens, s.gens[:b.NGenerations-1]...)
+
+		next := &rotatingState{
+			gens:  gens,
+			epoch: b.Clock(),
+		}
+		if b.state.CompareAndSwap(s, next) {
+			return
+		}
+	}
+}
+
+// `Reset`: drop all generations and start a new window now
+func (b *BloomRotating[T]) Reset() {
+	gens := make([]*BloomDS, b.NGenerations)
+	for i := range gens {
+		gens[i] = b.newGeneration()
+	}
+
+	b.state.Store(&rotatingState{
+		gens:  gens,
+		epoch: b.Clock(),
+	})
+}
+
+// `Union`: tries union of bloom_ds into the current generation, the generations hash with
+// murmur3 so b2 must too
+func (b1 *BloomRotating[T]) Union(b2 *BloomDS) bool {
+	s := b1.current()
+
+	cur := s.gens[0]
+	if !cur.compatible(b2) {
+		return false
+	}
+	for i := range cur.Filter {
+		atomic.OrUint64(&cur.Filter[i], b2.Filter[i])
+	}
+	return true
+}
+
+// `GetState`: return the union of all live generations
+func (b *BloomRotating[T]) GetState() BloomDS {
+	s := b.current()
+
+	bds := NewBloomDSCustom(b.ID, b.NBits, b.NHash, b.Seeds)
+	for _, gen := range s.gens {
+		for i := range bds.Filter {
+			bds.Filter[i] |= atomic.LoadUint64(&gen.Filter[i])
+		}
+	}
+	return bds
+}
+
+// `GetStates`: return a copy of every live generation, newest first
+func (b *BloomRotating[T]) GetStates() []BloomDS {
+	s := b.current()
+
+	states := make([]BloomDS, len(s.gens))
+	for gi, gen := range s.gens {
+		states[gi] = NewBloomDSCustom(b.ID, b.NBits, b.NHash, b.Seeds)
+		for i := range gen.Filter {
+			states[gi].Filter[i] = atomic.LoadUint64(&gen.Filter[i])
+		}
+	}
+	return states
+}
+
+// complie-time check
+var _ IBloom = (*BloomRotating[any])(nil)
@@ -2,47 +2,83 @@ package bloom
 
 import "sync"
 
-type BloomRW struct {
+type BloomRW[T any] struct {
 	State    BloomDS
 	Mu       sync.RWMutex
 	rareMu sync.RWMutex
+
+	encoder KeyEncoder[T]
 }
 
 // `NewBloomRWDefault` return a default `BloomRW` object
-func NewBloomRWDefault(id string, n_bits, n_hash uint64) *BloomRW {
+func NewBloomRWDefault(id string, n_bits, n_hash uint64) *BloomRW[any] {
 	return NewBloomRWCustom(id, n_bits, n_hash, [2]uint64{DefaultSeed1, DefaultSeed2})
 }
 
 // `NewBloomRWCustom` return a custom `BloomRW` object
-func NewBloomRWCustom(id string, n_bits, n_hash uint64, seeds [2]uint64) *BloomRW {
-	bloom := BloomRW{
-		State: NewBloomDSCustom(id, n_bits, n_hash, seeds),
+func NewBloomRWCustom(id string, n_bits, n_hash uint64, seeds [2]uint64) *BloomRW[any] {
+	return NewBloomRWTypedCustom[any](id, n_bits, n_hash, seeds, AnyEncoder{})
+}
+
+// `NewBloomRWFromBloomDS`: return a `BloomRW` using the data from the bloom_ds, an error
+// if its hasher is not registered
+func NewBloomRWFromBloomDS(b *BloomDS) (*BloomRW[any], error) {
+	return NewBloomRWTypedFromBloomDS[any](b, AnyEncoder{})
+}
+
+// `NewBloomRWTypedDefault` return a default `BloomRW` object for values encoded by encoder
+func NewBloomRWTypedDefault[T any](id string, n_bits, n_hash uint64, encoder KeyEncoder[T]) *BloomRW[T] {
+	return NewBloomRWTypedCustom(id, n_bits, n_hash, [2]uint64{DefaultSeed1, DefaultSeed2}, encoder)
+}
+
+// `NewBloomRWTypedCustom` return a custom `BloomRW` object for values encoded by encoder
+func NewBloomRWTypedCustom[T any](id string, n_bits, n_hash uint64, seeds [2]uint64, encoder KeyEncoder[T]) *BloomRW[T] {
+	bloom := BloomRW[T]{
+		State:   NewBloomDSCustom(id, n_bits, n_hash, seeds),
+		encoder: encoder,
 	}
 	return &bloom
 }
 
-// `NewBloomRWFromBloomDS`: return a `BloomRW` using the data from the bloom_ds
-func NewBloomRWFromBloomDS(b *BloomDS) *BloomRW {
-	bloom := NewBloomRWCustom(b.ID, b.NBits, b.NHash, b.Seeds)
+// `NewBloomRWTypedFromBloomDS`: return a `BloomRW` for values encoded by encoder using
+// the data from the bloom_ds, an error if its hasher is not registered
+func NewBloomRWTypedFromBloomDS[T any](b *BloomDS, encoder KeyEncoder[T]) (*BloomRW[T], error) {
+	bloom := NewBloomRWTypedCustom(b.ID, b.NBits, b.NHash, b.Seeds, encoder)
+	if err := bloom.State.copyHasher(b); err != nil {
+		return nil, err
+	}
 	bloom.Union(b)
-	return bloom
+	return bloom, nil
 }
 
 // `Add`: add a value to the set
-func (b *BloomRW) Add(value any) {
+func (b *BloomRW[T]) Add(value T) {
+	b.add(hashKey(&b.State, b.encoder, value))
+}
+
+// `AddE`: add a value to the set, an error wrapping `ErrEncodeFailed` if it cannot be encoded
+func (b *BloomRW[T]) AddE(value T) error {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return err
+	}
+	b.add(h1, h2)
+	return nil
+}
+
+// `add`: set the bits of the primary hashes h1 and h2
+func (b *BloomRW[T]) add(h1, h2 uint64) {
 	// unionRW mutex
 	b.rareMu.RLock()
 	defer b.rareMu.RUnlock()
 
-	// find the indices
-	indices := b.State.GetIndices(value)
-
 	// get write lock
 	b.Mu.Lock()
 	defer b.Mu.Unlock()
 
 	// find word index and offset, and set it to true
-	for _, index := range indices {
+	for i := uint64(0); i < b.State.NHash; i++ {
+		index := b.State.indexAt(h1, h2, i)
 		wi := index / 64
 		off := index % 64
 		b.State.Filter[wi] |= (1 << off)
@@ -50,20 +86,33 @@ func (b *BloomRW) Add(value any) {
 }
 
 // `Check`: check a value to the set (false negative: never, false positives: maybe)
-func (b *BloomRW) Check(value any) bool {
+func (b *BloomRW[T]) Check(value T) bool {
+	return b.check(hashKey(&b.State, b.encoder, value))
+}
+
+// `CheckE`: check a value to the set like `Check`, an error wrapping `ErrEncodeFailed` if it
+// cannot be encoded
+func (b *BloomRW[T]) CheckE(value T) (bool, error) {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return false, err
+	}
+	return b.check(h1, h2), nil
+}
+
+// `check`: check the bits of the primary hashes h1 and h2
+func (b *BloomRW[T]) check(h1, h2 uint64) bool {
 	// unionRW mutex
 	b.rareMu.RLock()
 	defer b.rareMu.RUnlock()
 
-	// find the indices
-	indices := b.State.GetIndices(value)
-
 	// get read lock
 	b.Mu.RLock()
 	defer b.Mu.RUnlock()
 
 	// find word index and offset, and check if it is false
-	for _, index := range indices {
+	for i := uint64(0); i < b.State.NHash; i++ {
+		index := b.State.indexAt(h1, h2, i)
 		wi := index / 64
 		off := index % 64
 
@@ -76,7 +125,7 @@ func (b *BloomRW) Check(value any) bool {
 }
 
 // `Reset`: resets bloom_ds
-func (b *BloomRW) Reset() {
+func (b *BloomRW[T]) Reset() {
 	// unionRW mutex
 	b.rareMu.Lock()
 	defer b.rareMu.Unlock()
@@ -85,7 +134,7 @@ func (b *BloomRW) Reset() {
 }
 
 // `Union`: tries state union
-func (b1 *BloomRW) Union(b2 *BloomDS) bool {
+func (b1 *BloomRW[T]) Union(b2 *BloomDS) bool {
 	// unionRW mutex
 	b1.rareMu.Lock()
 	defer b1.rareMu.Unlock()
@@ -94,7 +143,7 @@ func (b1 *BloomRW) Union(b2 *BloomDS) bool {
 }
 
 // `GetState`: return current State bool
-func (b *BloomRW) GetState() BloomDS {
+func (b *BloomRW[T]) GetState() BloomDS {
 	// unionRW mutex
 	b.rareMu.Lock()
 	defer b.rareMu.Unlock()
@@ -103,4 +152,4 @@ func (b *BloomRW) GetState() BloomDS {
 }
 
 // complie-time check
-var _ IBloom = (*BloomRW)(nil)
+var _ IBloom = (*BloomRW[any])(nil)
@@ -4,7 +4,7 @@ import (
 	"sync"
 )
 
-type BloomShard struct {
+type BloomShard[T any] struct {
 	State   BloomDS
 	NShards uint64
 	Shards  []sync.RWMutex
@@ -15,17 +15,35 @@ type BloomShard struct {
 	n_short        uint64
 	boundary_index uint64
 	rareMu         sync.RWMutex
+
+	encoder KeyEncoder[T]
 }
 
 // `NewBloomShardDefault` return a default `BloomShard` object
-func NewBloomShardDefault(id string, n_bits, n_hash, n_shards uint64) *BloomShard {
+func NewBloomShardDefault(id string, n_bits, n_hash, n_shards uint64) *BloomShard[any] {
 	return NewBloomShardCustom(id, n_bits, n_hash, n_shards, [2]uint64{DefaultSeed1, DefaultSeed2})
 }
 
 // `NewBloomShardCustom` return a custom `BloomShard` object
-func NewBloomShardCustom(id string, n_bits, n_hash, n_shards uint64, seeds [2]uint64) *BloomShard {
+func NewBloomShardCustom(id string, n_bits, n_hash, n_shards uint64, seeds [2]uint64) *BloomShard[any] {
+	return NewBloomShardTypedCustom[any](id, n_bits, n_hash, n_shards, seeds, AnyEncoder{})
+}
+
+// `NewBloomShardFromBloomDS`: return a `BloomShard` using the data from the bloom_ds, an
+// error if its hasher is not registered
+func NewBloomShardFromBloomDS(b *BloomDS, n_shard uint64) (*BloomShard[any], error) {
+	return NewBloomShardTypedFromBloomDS[any](b, n_shard, AnyEncoder{})
+}
+
+// `NewBloomShardTypedDefault` return a default `BloomShard` object for values encoded by encoder
+func NewBloomShardTypedDefault[T any](id string, n_bits, n_hash, n_shards uint64, encoder KeyEncoder[T]) *BloomShard[T] {
+	return NewBloomShardTypedCustom(id, n_bits, n_hash, n_shards, [2]uint64{DefaultSeed1, DefaultSeed2}, encoder)
+}
 
-	bloom := BloomShard{
+// `NewBloomShardTypedCustom` return a custom `BloomShard` object for values encoded by encoder
+func NewBloomShardTypedCustom[T any](id string, n_bits, n_hash, n_shards uint64, seeds [2]uint64, encoder KeyEncoder[T]) *BloomShard[T] {
+
+	bloom := BloomShard[T]{
 		State:   NewBloomDSCustom(id, n_bits, n_hash, seeds),
 		NShards: min(n_shards, n_bits),
 		Shards:  make([]sync.RWMutex, n_shards),
@@ -33,30 +51,49 @@ func NewBloomShardCustom(id string, n_bits, n_hash, n_shards uint64, seeds [2]ui
 		len_long:  (n_bits + n_shards - 1) / n_shards,
 		len_short: n_bits / n_shards,
 		n_long:    n_bits % n_shards,
+
+		encoder: encoder,
 	}
 	bloom.n_short = bloom.NShards - bloom.n_long
 	bloom.boundary_index = bloom.n_long * bloom.len_long
 	return &bloom
 }
 
-// `NewBloomShardFromBloomDS`: return a `BloomShard` using the data from the bloom_ds
-func NewBloomShardFromBloomDS(b *BloomDS, n_shard uint64) *BloomShard {
-	bloom := NewBloomShardCustom(b.ID, b.NBits, b.NHash, n_shard, b.Seeds)
+// `NewBloomShardTypedFromBloomDS`: return a `BloomShard` for values encoded by encoder
+// using the data from the bloom_ds, an error if its hasher is not registered
+func NewBloomShardTypedFromBloomDS[T any](b *BloomDS, n_shard uint64, encoder KeyEncoder[T]) (*BloomShard[T], error) {
+	bloom := NewBloomShardTypedCustom(b.ID, b.NBits, b.NHash, n_shard, b.Seeds, encoder)
+	if err := bloom.State.copyHasher(b); err != nil {
+		return nil, err
+	}
 	bloom.Union(b)
-	return bloom
+	return bloom, nil
 }
 
 // `Add`: add a value to the set
-func (b *BloomShard) Add(value any) {
+func (b *BloomShard[T]) Add(value T) {
+	b.add(hashKey(&b.State, b.encoder, value))
+}
+
+// `AddE`: add a value to the set, an error wrapping `ErrEncodeFailed` if it cannot be encoded
+func (b *BloomShard[T]) AddE(value T) error {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return err
+	}
+	b.add(h1, h2)
+	return nil
+}
+
+// `add`: set the bits of the primary hashes h1 and h2
+func (b *BloomShard[T]) add(h1, h2 uint64) {
 	// unionRW mutex
 	b.rareMu.RLock()
 	defer b.rareMu.RUnlock()
 
-	// find the indices
-	indices := b.State.GetIndices(value)
-
 	// find word index and offset, and set it to true
-	for _, index := range indices {
+	for i := uint64(0); i < b.State.NHash; i++ {
+		index := b.State.indexAt(h1, h2, i)
 		wi := index / 64
 		off := index % 64
 
@@ -68,16 +105,29 @@ func (b *BloomShard) Add(value any) {
 }
 
 // `Check`: check a value to the set (false negative: never, false positives: maybe)
-func (b *BloomShard) Check(value any) bool {
+func (b *BloomShard[T]) Check(value T) bool {
+	return b.check(hashKey(&b.State, b.encoder, value))
+}
+
+// `CheckE`: check a value to the set like `Check`, an error wrapping `ErrEncodeFailed` if it
+// cannot be encoded
+func (b *BloomShard[T]) CheckE(value T) (bool, error) {
+	h1, h2, err := hashKeyE(&b.State, b.encoder, value)
+	if err != nil {
+		return false, err
+	}
+	return b.check(h1, h2), nil
+}
+
+// `check`: check the bits of the primary hashes h1 and h2
+func (b *BloomShard[T]) check(h1, h2 uint64) bool {
 	// unionRW mutex
 	b.rareMu.RLock()
 	defer b.rareMu.RUnlock()
 
-	// find the indices
-	indices := b.State.GetIndices(value)
-
 	// find word index and offset, and check if it is false
-	for _, index := range indices {
+	for i := uint64(0); i < b.State.NHash; i++ {
+		index := b.State.indexAt(h1, h2, i)
 		wi := index / 64
 		off := index % 64
 
@@ -95,7 +145,7 @@ func (b *BloomShard) Check(value any) bool {
 }
 
 // `getShardId`: find the shard id for a given index
-func (b *BloomShard) getShardId(idx uint64) uint64 {
+func (b *BloomShard[T]) getShardId(idx uint64) uint64 {
 
 	// shard layout
 	// [0, n_long-1] - longer shards
@@ -108,7 +158,7 @@ func (b *BloomShard) getShardId(idx uint64) uint64 {
 }
 
 // `Reset`: resets bloom_ds
-func (b *BloomShard) Reset() {
+func (b *BloomShard[T]) Reset() {
 	// unionRW mutex
 	b.rareMu.Lock()
 	defer b.rareMu.Unlock()
@@ -117,7 +167,7 @@ func (b *BloomShard) Reset() {
 }
 
 // `Union`: tries state union
-func (b1 *BloomShard) Union(b2 *BloomDS) bool {
+func (b1 *BloomShard[T]) Union(b2 *BloomDS) bool {
 	// unionRW mutex
 	b1.rareMu.RLock()
 	defer b1.rareMu.RUnlock()
@@ -126,7 +176,7 @@ func (b1 *BloomShard) Union(b2 *BloomDS) bool {
 }
 
 // `GetState`: return current State bool
-func (b *BloomShard) GetState() BloomDS {
+func (b *BloomShard[T]) GetState() BloomDS {
 	// unionRW mutex
 	b.rareMu.Lock()
 	defer b.rareMu.Unlock()
@@ -135,4 +185,4 @@ func (b *BloomShard) GetState() BloomDS {
 }
 
 // complie-time check
-var _ IBloom = (*BloomShard)(nil)
+var _ IBloom = (*BloomShard[any])(nil)
@@ -0,0 +1,221 @@
+package bloom
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// `BloomStable`: stable bloom filter (Deng & Rafiei) for unbounded streams,
+// every Add decrements NDecrement cells before setting its own cells to Max,
+// so the fraction of zero cells converges instead of saturating, not safe for concurrent
+// use (the cells are packed and the decrements draw from an unsynchronized rng), callers
+// sharing it between goroutines must hold their own lock
+type BloomStable[T any] struct {
+	ID         string
+	NCells     uint64
+	NHash      uint64
+	NBitsCell  uint64
+	NDecrement uint64
+	Max        uint64
+	Seeds      [2]uint64
+	Cells      []uint64
+
+	rng     *rand.Rand
+	encoder KeyEncoder[T]
+}
+
+// `NewBloomStableDefault` return a default `BloomStable` object
+func NewBloomStableDefault(id string, n_cells, n_hash, n_bits_cell, n_decrement uint64) *BloomStable[any] {
+	return NewBloomStableCustom(id, n_cells, n_hash, n_bits_cell, n_decrement, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomStableCustom` return a custom `BloomStable` object
+func NewBloomStableCustom(id string, n_cells, n_hash, n_bits_cell, n_decrement uint64, seeds [2]uint64) *BloomStable[any] {
+	return NewBloomStableTypedCustom[any](id, n_cells, n_hash, n_bits_cell, n_decrement, seeds, AnyEncoder{})
+}
+
+// `NewBloomStableTypedDefault` return a default `BloomStable` object for values encoded by
+// encoder
+func NewBloomStableTypedDefault[T any](id string, n_cells, n_hash, n_bits_cell, n_decrement uint64, encoder KeyEncoder[T]) *BloomStable[T] {
+	return NewBloomStableTypedCustom(id, n_cells, n_hash, n_bits_cell, n_decrement, [2]uint64{DefaultSeed1, DefaultSeed2}, encoder)
+}
+
+// `NewBloomStableTypedCustom` return a custom `BloomStable` object for values encoded by
+// encoder
+func NewBloomStableTypedCustom[T any](id string, n_cells, n_hash, n_bits_cell, n_decrement uint64, seeds [2]uint64, encoder KeyEncoder[T]) *BloomStable[T] {
+
+	if n_cells < 1 {
+		fmt.Println("NewBloomStableCustom: n_cells is 0, using default 1024")
+		n_cells = 1024
+	}
+
+	if n_bits_cell < 1 || n_bits_cell > 8 {
+		fmt.Println("NewBloomStableCustom: n_bits_cell not in [1,8], using default 3")
+		n_bits_cell = 3
+	}
+
+	if n_decrement < 1 {
+		fmt.Println("NewBloomStableCustom: n_decrement is 0, the filter would never decay, using 1")
+		n_decrement = 1
+	}
+
+	bloom := BloomStable[T]{
+		ID:         id,
+		NCells:     n_cells,
+		NHash:      n_hash,
+		NBitsCell:  n_bits_cell,
+		NDecrement: min(n_decrement, n_cells),
+		Max:        uint64(1)<<n_bits_cell - 1,
+		Seeds:      seeds,
+		Cells:      make([]uint64, packedWords(n_cells, n_bits_cell)),
+
+		rng:     rand.New(rand.NewPCG(seeds[0], seeds[1])),
+		encoder: encoder,
+	}
+	return &bloom
+}
+
+// `Add`: add a value to the set, decaying NDecrement random cells first
+func (b *BloomStable[T]) Add(value T) {
+	b.add(keyIndices(b.encoder, value, b.NCells, b.NHash, b.Seeds))
+}
+
+// `AddE`: add a value to the set like `Add`, an error wrapping `ErrEncodeFailed` if it
+// cannot be encoded
+func (b *BloomStable[T]) AddE(value T) error {
+	indices, err := keyIndicesE(b.encoder, value, b.NCells, b.NHash, b.Seeds)
+	if err != nil {
+		return err
+	}
+	b.add(indices)
+	return nil
+}
+
+// `add`: decay NDecrement cells and set the cells at indices to max
+func (b *BloomStable[T]) add(indices []uint64) {
+	// decrement a run of NDecrement cells starting at a random cell
+	start := b.rng.Uint64N(b.NCells)
+	for i := uint64(0); i < b.NDecrement; i++ {
+		ci := (start + i) % b.NCells
+		if v := getPacked(b.Cells, ci, b.NBitsCell); v > 0 {
+			setPacked(b.Cells, ci, b.NBitsCell, v-1)
+		}
+	}
+
+	// set the cells of the value to max
+	for _, index := range indices {
+		setPacked(b.Cells, index, b.NBitsCell, b.Max)
+	}
+}
+
+// `Check`: check a value to the set (false negative: possible for old values, false positives: maybe)
+func (b *BloomStable[T]) Check(value T) bool {
+	return b.check(keyIndices(b.encoder, value, b.NCells, b.NHash, b.Seeds))
+}
+
+// `CheckE`: check a value to the set like `Check`, an error wrapping `ErrEncodeFailed` if it
+// cannot be encoded
+func (b *BloomStable[T]) CheckE(value T) (bool, error) {
+	indices, err := keyIndicesE(b.encoder, value, b.NCells, b.NHash, b.Seeds)
+	if err != nil {
+		return false, err
+	}
+	return b.check(indices), nil
+}
+
+// `check`: check the cells at indices
+func (b *BloomStable[T]) check(indices []uint64) bool {
+	// a zero cell means the value is absent (or has decayed)
+	for _, index := range indices {
+		if getPacked(b.Cells, index, b.NBitsCell) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// `Reset`: resets all cells
+func (b *BloomStable[T]) Reset() {
+	for i := range b.Cells {
+		b.Cells[i] = 0
+	}
+}
+
+// `GetFalsePositiveProbabilityEstimate`: stationary false positive rate of the filter
+func (b *BloomStable[T]) GetFalsePositiveProbabilityEstimate() float64 {
+	return GetStableFalsePositiveEstimate(b.NCells, b.NHash, b.Max, b.NDecrement)
+}
+
+// `GetFalseNegativeProbabilityEstimate`: false negative rate for a value last added gap Adds ago
+func (b *BloomStable[T]) GetFalseNegativeProbabilityEstimate(gap uint64) float64 {
+	return GetStableFalseNegativeEstimate(b.NCells, b.NHash, b.Max, b.NDecrement, gap)
+}
+
+// `GetStableFalsePositiveEstimate`: stationary false positive rate for a stable bloom filter
+// with n_cells cells of value up to max_cell, n_hash hashes and n_decrement decrements per Add
+func GetStableFalsePositiveEstimate(n_cells, n_hash, max_cell, n_decrement uint64) float64 {
+	k := float64(n_hash)
+	m := float64(n_cells)
+	p := float64(n_decrement)
+
+	// probability that a cell is zero once the filter is stable
+	p_zero := math.Pow(1/(1+1/(p*(1/k-1/m))), float64(max_cell))
+
+	return math.Pow(1-p_zero, k)
+}
+
+// `GetStableFalseNegativeEstimate`: false negative rate for a value whose last Add happened
+// gap Adds ago, each of its cells is tracked as a markov chain over [0, max_cell]
+func GetStableFalseNegativeEstimate(n_cells, n_hash, max_cell, n_decrement, gap uint64) float64 {
+	m := float64(n_cells)
+
+	// per Add: a cell is decremented, then possibly set back to max
+	p_dec := float64(min(n_decrement, n_cells)) / m
+	p_set := 1 - math.Pow(1-1/m, float64(n_hash))
+
+	// the value's cells start at max_cell
+	dist := make([]float64, max_cell+1)
+	next := make([]float64, max_cell+1)
+	dist[max_cell] = 1
+
+	for step := uint64(0); step < gap; step++ {
+		for i := range next {
+			next[i] = 0
+		}
+		for v := uint64(0); v <= max_cell; v++ {
+			if v == 0 {
+				next[0] += dist[0]
+				continue
+			}
+			next[v-1] += dist[v] * p_dec
+			next[v] += dist[v] * (1 - p_dec)
+		}
+		for v := uint64(0); v < max_cell; v++ {
+			next[max_cell] += next[v] * p_set
+			next[v] *= 1 - p_set
+		}
+		dist, next = next, dist
+	}
+
+	// a false negative needs at least one zero cell
+	return 1 - math.Pow(1-dist[0], float64(n_hash))
+}
+
+// `GetStableOptimalDecrement`: return the n_decrement that reaches prob_fp once the filter is stable
+func GetStableOptimalDecrement(n_cells, n_hash, max_cell uint64, prob_fp float64) uint64 {
+
+	if prob_fp <= 0 || prob_fp >= 1 {
+		fmt.Println("GetStableOptimalDecrement: prob_fp not in (0,1), using default 0.01")
+		prob_fp = 0.01
+	}
+
+	k := float64(n_hash)
+	m := float64(n_cells)
+
+	p_zero := 1 - math.Pow(prob_fp, 1/k)
+	n_decrement := 1 / ((1/k - 1/m) * (math.Pow(p_zero, -1/float64(max_cell)) - 1))
+
+	return max(uint64(math.Ceil(n_decrement)), 1)
+}
@@ -1,10 +1,22 @@
 package bloom
 
 import (
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // helper to exercise a bloom implementation via IBloom
@@ -166,3 +178,1660 @@ func TestSaveCreatesFileWithCorrectName(t *testing.T) {
 		t.Fatalf("expected file %s to exist, stat error: %v", p, err)
 	}
 }
+
+func TestBloomStableDoesNotSaturate(t *testing.T) {
+	b := NewBloomStableDefault("stable", 4096, 3, 3, 10)
+	for i := 0; i < 100000; i++ {
+		b.Add(i)
+		// the latest value is always present
+		if !b.Check(i) {
+			t.Fatalf("value %d missing right after add", i)
+		}
+	}
+
+	zero := 0
+	for i := uint64(0); i < b.NCells; i++ {
+		if getPacked(b.Cells, i, b.NBitsCell) == 0 {
+			zero++
+		}
+	}
+	if zero == 0 {
+		t.Fatal("stable bloom saturated: no zero cells left")
+	}
+
+	fp := b.GetFalsePositiveProbabilityEstimate()
+	if fp <= 0 || fp >= 1 {
+		t.Fatalf("unexpected stationary false positive estimate %f", fp)
+	}
+	if b.GetFalseNegativeProbabilityEstimate(10) >= b.GetFalseNegativeProbabilityEstimate(1000) {
+		t.Fatal("expected false negatives to grow with the gap")
+	}
+
+	p := GetStableOptimalDecrement(4096, 3, 7, 0.02)
+	if got := GetStableFalsePositiveEstimate(4096, 3, 7, p); got > 0.02 {
+		t.Fatalf("optimal decrement %d gives fp %f > 0.02", p, got)
+	}
+}
+
+func TestBloomStableInvalidParameters(t *testing.T) {
+	b := NewBloomStableDefault("stable", 0, 3, 3, 0)
+	if b.NCells == 0 || b.NDecrement == 0 {
+		t.Fatalf("invalid parameters kept: %d cells, %d decrements", b.NCells, b.NDecrement)
+	}
+	b.Add("x")
+	if !b.Check("x") {
+		t.Fatal("false negative")
+	}
+}
+
+func TestBloomRotatingExpiry(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+
+	b := NewBloomRotatingCustom("rotating", 1024, 3, 3, time.Hour, [2]uint64{DefaultSeed1, DefaultSeed2}, clock)
+	b.Add("a")
+
+	now = now.Add(90 * time.Minute)
+	b.Add("b")
+	if !b.Check("a") || !b.Check("b") {
+		t.Fatal("values should be visible inside the window")
+	}
+
+	// "a" was added 3 generations ago and has been dropped
+	now = now.Add(90 * time.Minute)
+	if b.Check("a") {
+		t.Fatal("expired value should not be visible")
+	}
+	if !b.Check("b") {
+		t.Fatal("value should still be visible")
+	}
+
+	// a long pause drops everything
+	now = now.Add(24 * time.Hour)
+	if b.Check("b") {
+		t.Fatal("all generations should have expired")
+	}
+}
+
+func TestBloomRotatingConcurrent(t *testing.T) {
+	b := NewBloomRotatingDefault("rotating", 4096, 3, 4, time.Millisecond)
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				b.Add(g*1000 + i)
+				b.Check(i)
+				if i%100 == 0 {
+					b.Rotate()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := len(b.GetStates()); n != 4 {
+		t.Fatalf("expected 4 generations, got %d", n)
+	}
+}
+
+func TestBloomAgePartitionedWindow(t *testing.T) {
+	b := NewBloomAgePartitionedDefault("apbf", 8, 4, 100)
+	for i := 0; i < 3000; i++ {
+		b.Add(i)
+	}
+
+	lo, hi := b.GetWindow()
+	if lo != 400 || hi != 500 {
+		t.Fatalf("unexpected window (%d, %d)", lo, hi)
+	}
+	if st := b.GetState(); st.NHash != b.K+b.L || uint64(len(st.GetIndices(7))) != b.K+b.L {
+		t.Fatalf("state records %d hashes, expected %d", st.NHash, b.K+b.L)
+	}
+	for i := 3000 - int(lo); i < 3000; i++ {
+		if !b.Check(i) {
+			t.Fatalf("value %d inside the window is missing", i)
+		}
+	}
+
+	// values whose slices have all been dropped are forgotten
+	old := 0
+	for i := 0; i < 1000; i++ {
+		if b.Check(i) {
+			old++
+		}
+	}
+	if old > 20 {
+		t.Fatalf("too many expired values still visible: %d", old)
+	}
+
+	fp := b.GetFalsePositiveProbabilityEstimate()
+	if fp <= 0 || fp >= 0.01 {
+		t.Fatalf("unexpected false positive estimate %f", fp)
+	}
+
+	// snapshot and restore
+	d := t.TempDir()
+	if err := b.Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	bl := BloomAgePartitioned{State: BloomDS{ID: "apbf"}}
+	if err := bl.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if bl.Head != b.Head || bl.Count != b.Count || !bl.Check(2999) {
+		t.Fatal("loaded filter does not match")
+	}
+
+	// a fresh snapshot loaded into a used filter restarts its window
+	if err := NewBloomAgePartitionedDefault("apbf", 8, 4, 100).Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	if err := b.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if b.Head != 0 || b.Count != 0 || b.Check(2999) {
+		t.Fatalf("loaded filter kept head %d and count %d", b.Head, b.Count)
+	}
+}
+
+func TestBinaryFuse(t *testing.T) {
+	keys := make([]any, 0, 20001)
+	for i := 0; i < 20000; i++ {
+		keys = append(keys, i)
+	}
+	keys = append(keys, 7) // duplicates are fine
+
+	f, err := NewBinaryFuseDefault("fuse", keys)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	for _, k := range keys {
+		if !f.Check(k) {
+			t.Fatalf("key %v missing", k)
+		}
+	}
+
+	fp := 0
+	for i := 20000; i < 120000; i++ {
+		if f.Check(i) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > 0.006 {
+		t.Fatalf("false positive rate too high: %f", rate)
+	}
+	if bpk := float64(len(f.Fingerprints)*8) / 20000; bpk > 10 {
+		t.Fatalf("too many bits per key: %f", bpk)
+	}
+
+	// small and empty sets
+	for n := 0; n < 10; n++ {
+		if _, err := NewBinaryFuseDefault("small", keys[:n]); err != nil {
+			t.Fatalf("build error for %d keys: %v", n, err)
+		}
+	}
+
+	d := t.TempDir()
+	if err := f.Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	fl := BinaryFuse{ID: "fuse"}
+	if err := fl.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if !fl.Check(123) {
+		t.Fatal("loaded filter lost a key")
+	}
+}
+
+func TestQuotientFilterExactOnFingerprints(t *testing.T) {
+	qf := NewQuotientFilterDefault("qf", 6, 10)
+	present := map[uint64]bool{}
+
+	// interleave adds and deletes, the filter is exact on fingerprints
+	for i := 0; i < 3000; i++ {
+		v := (i * 7919) % 500
+		fp := qf.fingerprint(v)
+		if i%3 == 2 {
+			if qf.Delete(v) != present[fp] {
+				t.Fatalf("delete of %d disagrees with the reference", v)
+			}
+			delete(present, fp)
+		} else {
+			if !qf.Add(v) {
+				t.Fatalf("add of %d failed", v)
+			}
+			// doubling changes the fingerprint split but not the fingerprint
+			present[qf.fingerprint(v)] = true
+		}
+
+		if qf.NEntries != uint64(len(present)) {
+			t.Fatalf("entry count %d, want %d", qf.NEntries, len(present))
+		}
+	}
+	if qf.QBits == 6 {
+		t.Fatal("expected the filter to double")
+	}
+	for v := 0; v < 500; v++ {
+		if qf.Check(v) != present[qf.fingerprint(v)] {
+			t.Fatalf("check of %d disagrees with the reference", v)
+		}
+	}
+	if got := len(qf.fingerprints()); got != len(present) {
+		t.Fatalf("iterated %d fingerprints, want %d", got, len(present))
+	}
+}
+
+func TestQuotientFilterUnionSaveLoad(t *testing.T) {
+	a := NewQuotientFilterDefault("qa", 8, 8)
+	b := NewQuotientFilterDefault("qb", 9, 7)
+	for i := 0; i < 150; i++ {
+		a.Add(i)
+		b.Add(1000 + i)
+	}
+	if !a.Union(b) {
+		t.Fatal("union of compatible filters failed")
+	}
+	for i := 0; i < 150; i++ {
+		if !a.Check(i) || !a.Check(1000+i) {
+			t.Fatalf("value %d lost in union", i)
+		}
+	}
+	if a.Union(NewQuotientFilterDefault("qc", 8, 9)) {
+		t.Fatal("union with a different fingerprint size should fail")
+	}
+
+	d := t.TempDir()
+	if err := a.Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	l := QuotientFilter{ID: "qa"}
+	if err := l.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if !l.Check(1042) || l.NEntries != a.NEntries {
+		t.Fatal("loaded filter does not match")
+	}
+}
+
+func TestQuotientFilterFullAndWide(t *testing.T) {
+	// r_bits + 3 metadata bits must fit in a word
+	if qf := NewQuotientFilterDefault("qw", 1, 62); qf.RBits > 61 {
+		t.Fatalf("accepted %d remainder bits", qf.RBits)
+	}
+
+	// two halves crowded at the last quotients fill a table that can not double, with the
+	// runs wrapping past the last slot
+	a := NewQuotientFilterDefault("qa", 3, 1)
+	b := NewQuotientFilterDefault("qb", 3, 1)
+	for i := uint64(0); i < 4; i++ {
+		a.insert((6+i/2)<<1 | i%2)
+		b.insert((4+i/2)<<1 | i%2)
+	}
+	if !a.Union(b) || a.NEntries != a.size() {
+		t.Fatalf("union did not fill the table: %d of %d", a.NEntries, a.size())
+	}
+	fps := a.fingerprints()
+	if uint64(len(fps)) != a.NEntries {
+		t.Fatalf("read %d fingerprints, expected %d", len(fps), a.NEntries)
+	}
+	for _, fp := range fps {
+		if !a.contains(fp) {
+			t.Fatalf("fingerprint %x lost", fp)
+		}
+	}
+}
+
+func TestRibbon(t *testing.T) {
+	n := 200000
+	keys := func(yield func(any) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	r, err := NewRibbonFromSeq("ribbon", keys, 0.01, [2]uint64{DefaultSeed1, DefaultSeed2})
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	if r.NShards < 2 {
+		t.Fatalf("expected several shards, got %d", r.NShards)
+	}
+	for i := 0; i < n; i++ {
+		if !r.Check(i) {
+			t.Fatalf("key %d missing", i)
+		}
+	}
+
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if r.Check(i) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / float64(n); rate > 0.015 {
+		t.Fatalf("false positive rate too high: %f", rate)
+	}
+
+	// smaller than the optimal bloom filter for the same rate
+	n_bits, _ := GetOptimalParameters(uint64(n), 0.01)
+	if ribbon_bits := uint64(len(r.Solution) * 64); ribbon_bits >= n_bits {
+		t.Fatalf("ribbon uses %d bits, bloom %d", ribbon_bits, n_bits)
+	}
+
+	if _, err := NewRibbonDefault("empty", nil, 0.01); err != nil {
+		t.Fatalf("build error for empty set: %v", err)
+	}
+
+	d := t.TempDir()
+	if err := r.Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	rl := Ribbon{ID: "ribbon"}
+	if err := rl.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if !rl.Check(4242) {
+		t.Fatal("loaded filter lost a key")
+	}
+}
+
+func TestGCS(t *testing.T) {
+	keys := make([]any, 0, 5000)
+	for i := 0; i < 5000; i++ {
+		keys = append(keys, fmt.Sprintf("script-%d", i))
+	}
+	g := NewGCSDefault("gcs", keys)
+	for _, k := range keys[:100] {
+		if !g.Check(k) {
+			t.Fatalf("key %v missing", k)
+		}
+	}
+
+	// batch matching agrees with single lookups
+	queries := append([]any{"nope", "script-4999", 17}, keys[1000:1100]...)
+	batch := g.CheckBatch(queries)
+	for i, q := range queries {
+		if batch[i] != g.Check(q) {
+			t.Fatalf("batch result for %v disagrees", q)
+		}
+	}
+	if !g.CheckAny([]any{"nope", "script-3"}) || g.CheckAny([]any{"nope", "nada"}) {
+		t.Fatal("unexpected CheckAny result")
+	}
+
+	// far smaller than a bloom filter with the same false positive rate
+	data := g.Bytes()
+	n_bits, _ := GetOptimalParameters(5000, g.GetFalsePositiveProbabilityEstimate())
+	if uint64(len(data))*8 >= n_bits {
+		t.Fatalf("gcs uses %d bits, bloom %d", len(data)*8, n_bits)
+	}
+
+	gl, err := NewGCSFromBytes("gcs", data, GCSDefaultP, GCSDefaultM, DefaultSeed1)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if !gl.Check("script-2500") || gl.N != g.N {
+		t.Fatal("decoded filter does not match")
+	}
+
+	if NewGCSDefault("empty", nil).Check("x") {
+		t.Fatal("empty set should not match")
+	}
+}
+
+func TestCountMin(t *testing.T) {
+	width, depth := GetCountMinParameters(0.01, 0.01)
+	if width != 272 || depth != 5 {
+		t.Fatalf("unexpected parameters (%d, %d)", width, depth)
+	}
+
+	plain := NewCountMinDefault("cm", width, depth)
+	cons := NewCountMinDefault("cm", width, depth)
+	cons.Conservative = true
+
+	// key i is seen i times, from many goroutines
+	wg := sync.WaitGroup{}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				for j := 0; j < i; j += 4 {
+					plain.Increment(i, 1)
+					cons.Increment(i, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 200; i++ {
+		truth := uint64(4 * ((i + 3) / 4))
+		p, c := plain.Estimate(i), cons.Estimate(i)
+		if p < truth || c < truth {
+			t.Fatalf("underestimate for %d: %d %d < %d", i, p, c, truth)
+		}
+		if c > p {
+			t.Fatalf("conservative estimate %d above plain %d", c, p)
+		}
+		if float64(p-truth) > plain.GetErrorBound() {
+			t.Fatalf("estimate for %d off by more than the bound", i)
+		}
+	}
+
+	other := NewCountMinDefault("cm", width, depth)
+	other.Increment("x", 5)
+	if !plain.Merge(other) || plain.Estimate("x") < 5 {
+		t.Fatal("merge lost counts")
+	}
+	if plain.Merge(NewCountMinDefault("cm", width+1, depth)) {
+		t.Fatal("merge with different width should fail")
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	h := NewHyperLogLogDefault("hll", 14)
+	if h.Count() != 0 {
+		t.Fatal("empty estimator should count 0")
+	}
+
+	check := func(n int) {
+		t.Helper()
+		got := float64(h.Count())
+		if math.Abs(got-float64(n))/float64(n) > 0.03 {
+			t.Fatalf("estimate %f too far from %d (sparse: %v)", got, n, h.Sparse)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		h.Add(i)
+		h.Add(i) // duplicates do not count
+	}
+	if !h.Sparse {
+		t.Fatal("expected the sparse representation for 1000 values")
+	}
+	check(1000)
+
+	for i := 1000; i < 200000; i++ {
+		h.Add(i)
+	}
+	if h.Sparse {
+		t.Fatal("expected the dense representation for 200000 values")
+	}
+	check(200000)
+
+	// merge a sparse estimator into a dense one
+	other := NewHyperLogLogDefault("hll", 14)
+	for i := 200000; i < 201000; i++ {
+		other.Add(i)
+	}
+	if !h.Merge(other) {
+		t.Fatal("merge of compatible estimators failed")
+	}
+	check(201000)
+	if h.Merge(NewHyperLogLogDefault("hll", 12)) {
+		t.Fatal("merge with a different precision should fail")
+	}
+
+	d := t.TempDir()
+	if err := other.Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	hl := HyperLogLog{ID: "hll"}
+	if err := hl.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if hl.Count() != other.Count() {
+		t.Fatal("loaded estimator does not match")
+	}
+
+	// a dense snapshot loaded into a sparse estimator from the constructor
+	if err := h.Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	dense := NewHyperLogLogDefault("hll", 14)
+	dense.Add("pending")
+	if err := dense.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if dense.Sparse || dense.Count() != h.Count() {
+		t.Fatalf("loaded dense estimator counts %d, expected %d", dense.Count(), h.Count())
+	}
+}
+
+func TestMinHash(t *testing.T) {
+	// sets {0..999} and {500..1499} have jaccard similarity 500/1500
+	a := NewMinHashDefault("a", 256)
+	b := NewMinHashDefault("b", 256)
+	for i := 0; i < 1000; i++ {
+		a.Add(i)
+		b.Add(i + 500)
+	}
+
+	want := 1.0 / 3
+	if s := a.Similarity(b); math.Abs(s-want) > 0.1 {
+		t.Fatalf("similarity %.3f, expected about %.3f", s, want)
+	}
+	if s := a.BBit(4).Similarity(b.BBit(4)); math.Abs(s-want) > 0.1 {
+		t.Fatalf("b-bit similarity %.3f, expected about %.3f", s, want)
+	}
+
+	// the merged signature is the signature of the union
+	u := NewMinHashDefault("u", 256)
+	for i := 0; i < 1500; i++ {
+		u.Add(i)
+	}
+	if !a.Merge(b) || a.Similarity(u) != 1 {
+		t.Fatal("merged signature does not match the union")
+	}
+}
+
+func TestMinHashLSH(t *testing.T) {
+	lsh := NewMinHashLSHThreshold(128, 0.5)
+	if lsh.Bands*lsh.Rows > 128 {
+		t.Fatalf("%d bands of %d rows do not fit 128 hashes", lsh.Bands, lsh.Rows)
+	}
+
+	// 100 disjoint sets, each query is a near duplicate of one of them
+	for s := 0; s < 100; s++ {
+		m := NewMinHashDefault("", 128)
+		for i := 0; i < 100; i++ {
+			m.Add(s*1000 + i)
+		}
+		lsh.Insert(fmt.Sprint(s), m)
+	}
+
+	for s := 0; s < 100; s++ {
+		q := NewMinHashDefault("", 128)
+		for i := 5; i < 105; i++ {
+			q.Add(s*1000 + i)
+		}
+		candidates := lsh.Query(q)
+		if !slices.Contains(candidates, fmt.Sprint(s)) {
+			t.Fatalf("near duplicate of set %d not found", s)
+		}
+		if len(candidates) > 5 {
+			t.Fatalf("too many candidates for set %d: %v", s, candidates)
+		}
+	}
+}
+
+func TestIBLT(t *testing.T) {
+	a := NewIBLTDefault("a", GetIBLTCells(40), 16)
+	b := NewIBLTDefault("b", GetIBLTCells(40), 16)
+	for i := 0; i < 10000; i++ {
+		a.Insert([]byte(fmt.Sprint(i)))
+		if i >= 20 {
+			b.Insert([]byte(fmt.Sprint(i)))
+		}
+	}
+	for i := 10000; i < 10020; i++ {
+		b.Insert([]byte(fmt.Sprint(i)))
+	}
+	if a.Insert(make([]byte, 16)) {
+		t.Fatal("key longer than the key size should be rejected")
+	}
+
+	if !a.Subtract(b) {
+		t.Fatal("subtract of compatible tables failed")
+	}
+	added, removed, ok := a.ListEntries()
+	if !ok || len(added) != 20 || len(removed) != 20 {
+		t.Fatalf("decoded %d added, %d removed (ok=%v), expected 20 and 20", len(added), len(removed), ok)
+	}
+	for _, key := range added {
+		if n, _ := strconv.Atoi(string(key)); n >= 20 {
+			t.Fatalf("unexpected added key %q", key)
+		}
+	}
+
+	// deleting the added keys leaves only the removed ones
+	for _, key := range added {
+		a.Delete(key)
+	}
+	if added, removed, ok = a.ListEntries(); !ok || len(added) != 0 || len(removed) != 20 {
+		t.Fatal("delete did not remove the keys")
+	}
+
+	// malformed tables are refused instead of panicking
+	short := *b
+	short.Counts = short.Counts[:1]
+	keys := *b
+	keys.KeySums = nil
+	split := *b
+	split.NHash = 0
+	for _, bad := range []*IBLT{nil, &short, &keys, &split} {
+		if a.Subtract(bad) {
+			t.Fatal("subtract of a malformed table succeeded")
+		}
+	}
+	s1 := NewIBLTStrataDefault(16)
+	s1.Insert([]byte("key"))
+	for _, i := range []int{0, ibltStrataCount - 1} {
+		s2 := NewIBLTStrataDefault(16)
+		stratum := *s2.Strata[i]
+		stratum.HashSums = stratum.HashSums[:1]
+		s2.Strata[i] = &stratum
+		if s1.Estimate(s2) != 0 {
+			t.Fatal("estimate against a malformed estimator should be 0")
+		}
+		s2.Strata[i] = nil
+		if s1.Estimate(s2) != 0 {
+			t.Fatal("estimate against a missing stratum should be 0")
+		}
+	}
+}
+
+func TestReconcileIBLT(t *testing.T) {
+	// 100000 shared keys, 300 only local and 200 only remote
+	local := make([][]byte, 0)
+	remote := make([][]byte, 0)
+	for i := 0; i < 100000; i++ {
+		local = append(local, []byte(fmt.Sprint(i)))
+		remote = append(remote, []byte(fmt.Sprint(i)))
+	}
+	for i := 0; i < 300; i++ {
+		local = append(local, []byte(fmt.Sprint("l", i)))
+	}
+	for i := 0; i < 200; i++ {
+		remote = append(remote, []byte(fmt.Sprint("r", i)))
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	type result struct {
+		mine, theirs [][]byte
+		err          error
+	}
+	done := make(chan result)
+	go func() {
+		mine, theirs, err := ReconcileIBLT(c2, remote, 16)
+		done <- result{mine, theirs, err}
+	}()
+
+	mine, theirs, err := ReconcileIBLT(c1, local, 16)
+	if err != nil {
+		t.Fatalf("reconcile error: %v", err)
+	}
+	if len(mine) != 300 || len(theirs) != 200 {
+		t.Fatalf("got %d local and %d remote keys, expected 300 and 200", len(mine), len(theirs))
+	}
+	r := <-done
+	if r.err != nil || len(r.mine) != 200 || len(r.theirs) != 300 {
+		t.Fatalf("peer got %d local and %d remote keys (err=%v)", len(r.mine), len(r.theirs), r.err)
+	}
+}
+
+func TestReconcileIBLTBadPeer(t *testing.T) {
+	// the peer sends a value of the wrong type and never reads, the pending send must not
+	// stay blocked
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go gob.NewEncoder(c2).Encode("not a strata estimator")
+
+	done := make(chan error)
+	go func() {
+		_, _, err := ReconcileIBLT(c1, [][]byte{[]byte("key")}, 16)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("reconcile with a bad peer succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reconcile blocked on a bad peer")
+	}
+}
+
+func TestBloomier(t *testing.T) {
+	values := make(map[string]uint8)
+	for i := 0; i < 100000; i++ {
+		values[fmt.Sprint("key", i)] = uint8(i % 16)
+	}
+
+	b, err := NewBloomierDefault("bloomier", values, 4)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	for key, value := range values {
+		if got := b.Get(key); got != value {
+			t.Fatalf("Get(%q) = %d, expected %d", key, got, value)
+		}
+	}
+
+	d := t.TempDir()
+	if err := b.Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	bl := Bloomier{ID: "bloomier"}
+	if err := bl.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if bl.Get("key42") != values["key42"] {
+		t.Fatal("loaded filter does not match")
+	}
+
+	// the same key with two values cannot be built
+	pairs := func(yield func(any, uint8) bool) {
+		_ = yield("x", 1) && yield("x", 2)
+	}
+	if _, err := NewBloomierFromSeq("bad", pairs, 4, [2]uint64{DefaultSeed1, DefaultSeed2}); !errors.Is(err, ErrBuildFailed) {
+		t.Fatalf("expected ErrBuildFailed, got %v", err)
+	}
+}
+
+func TestBloomDeletable(t *testing.T) {
+	b := NewBloomDeletableDefault("dlbf", 1<<16, 4, 1<<12)
+	for i := 0; i < 1000; i++ {
+		b.Add(i)
+	}
+
+	// removal never causes false negatives for the other values
+	removed := 0
+	for i := 0; i < 500; i++ {
+		if b.Remove(i) {
+			removed++
+			if b.Check(i) {
+				t.Fatalf("%d still present after an effective removal", i)
+			}
+		}
+	}
+	for i := 500; i < 1000; i++ {
+		if !b.Check(i) {
+			t.Fatalf("false negative for %d after removals", i)
+		}
+	}
+	if removed < 400 {
+		t.Fatalf("only %d of 500 removals were effective", removed)
+	}
+	if b.Remove("absent") {
+		t.Fatal("removal of an absent value should not be effective")
+	}
+
+	// after a union every region holding a bit of the other filter is collided
+	other := NewBloomDefault("dlbf", 1<<16, 4)
+	other.Add(500)
+	if !b.Union(&other.State) || b.Remove(500) {
+		t.Fatal("value of a united filter should not be removable")
+	}
+
+	d := t.TempDir()
+	if err := b.Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	bl := BloomDeletable[any]{State: BloomDS{ID: "dlbf"}}
+	if err := bl.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if bl.GetDeletableFraction() != b.GetDeletableFraction() || !bl.Check(999) {
+		t.Fatal("loaded filter does not match")
+	}
+}
+
+func TestBloomRange(t *testing.T) {
+	b := NewBloomRangeDefault("range", 1<<18, 5, 1<<10)
+	for i := uint64(1); i <= 1000; i++ {
+		b.AddUint64(i * 10000)
+	}
+
+	// every range holding a key is found
+	for i := uint64(1); i <= 1000; i++ {
+		if !b.CheckUint64(i*10000) || !b.MayContainRange(i*10000-500, i*10000+3) {
+			t.Fatalf("range around %d not found", i*10000)
+		}
+	}
+
+	// empty ranges between the keys
+	fp := 0
+	for i := uint64(1); i <= 1000; i++ {
+		if b.MayContainRange(i*10000+1, i*10000+1000) {
+			fp++
+		}
+	}
+	est := b.GetFalsePositiveProbabilityEstimate(1000, 1000)
+	if float64(fp) > 1000*est+10 {
+		t.Fatalf("%d false positive ranges, estimate %.4f", fp, est)
+	}
+
+	if !b.MayContainRange(1, 1<<20) {
+		t.Fatal("ranges wider than MaxWidth should be reported")
+	}
+	if b.MayContainRange(5, 4) {
+		t.Fatal("an inverted range is empty")
+	}
+
+	// the cover stops at the end of the key space
+	b.AddUint64(math.MaxUint64)
+	if !b.MayContainRange(math.MaxUint64-10, math.MaxUint64) {
+		t.Fatal("range at the end of the key space not found")
+	}
+}
+
+func TestBloomPrefix(t *testing.T) {
+	b := NewBloomPrefixDefault("prefix", 1<<18, 5, []uint64{3}, 8)
+	for i := 0; i < 1000; i++ {
+		b.Add(fmt.Sprintf("user:%06d:profile", i))
+	}
+	if b.NEntries != 1000*b.GetEntriesPerKey(uint64(len("user:000000:profile"))) {
+		t.Fatalf("%d entries, expected %d per key", b.NEntries, b.GetEntriesPerKey(19))
+	}
+
+	for _, p := range []string{"use", "user:000", "user:000999:pro", "user:000999:profile"} {
+		if !b.MayContainPrefix(p) {
+			t.Fatalf("prefix %q not found", p)
+		}
+	}
+	if !b.Check("user:000042:profile") {
+		t.Fatal("key not found")
+	}
+
+	fp := 0
+	for i := 0; i < 1000; i++ {
+		if b.MayContainPrefix(fmt.Sprintf("item:%03d", i)) {
+			fp++
+		}
+	}
+	est := b.GetFalsePositiveProbabilityEstimate()
+	if float64(fp) > 1000*est+10 {
+		t.Fatalf("%d false positive prefixes, estimate %.4f", fp, est)
+	}
+	if !b.MayContainPrefix("u") {
+		t.Fatal("a prefix shorter than every configured length is always possible")
+	}
+}
+
+func TestBloomNGram(t *testing.T) {
+	b := NewBloomNGramDefault("ngram", 1<<16, 4, 3, true)
+	b.Add("GET /api/v1/users 200 Größe")
+	b.Add("POST /api/v1/orders 500")
+
+	for _, s := range []string{"/api/v1", "orders 500", "USERS", "größe", "ab"} {
+		if !b.MayContainSubstring(s) {
+			t.Fatalf("substring %q not found", s)
+		}
+	}
+	for _, s := range []string{"DELETE", "/api/v2", "timeout"} {
+		if b.MayContainSubstring(s) {
+			t.Fatalf("unexpected substring %q", s)
+		}
+	}
+
+	exact := NewBloomNGramDefault("ngram", 1<<16, 4, 3, false)
+	exact.Add("GET /api")
+	if exact.MayContainSubstring("get") {
+		t.Fatal("case folding is disabled")
+	}
+}
+
+func TestBloomDomain(t *testing.T) {
+	b := NewBloomDomainDefault("domain", 1<<16, 5)
+	list := `# blocklist
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.net # inline comment
+0.0.0.0 0.0.0.0
+*.Doubleclick.NET.
+xn--bcher-kva.example
+`
+	n, err := b.AddList(strings.NewReader(list))
+	if err != nil || n != 4 {
+		t.Fatalf("added %d domains (err=%v), expected 4", n, err)
+	}
+
+	for _, name := range []string{"ads.example.com", "x.ADS.example.com.", "stats.g.doubleclick.net", "xn--bcher-kva.example"} {
+		if !b.Check(name) {
+			t.Fatalf("%q should be blocked", name)
+		}
+	}
+	for _, name := range []string{"example.com", "localhost", "example.net", "doubleclick.org", ""} {
+		if b.Check(name) {
+			t.Fatalf("%q should not be blocked", name)
+		}
+	}
+	if b.CheckExact("x.ads.example.com") {
+		t.Fatal("CheckExact should not match parent domains")
+	}
+
+	// unicode names are matched by their punycode form
+	if !b.Check("Bücher.example") || !b.CheckExact("bücher.example.") {
+		t.Fatal("bücher.example should match xn--bcher-kva.example")
+	}
+	for name, expected := range map[string]string{
+		"münchen.de":         "xn--mnchen-3ya.de",
+		"www.例え.jp":          "www.xn--r8jz45g.jp",
+		"*.ПРИМЕР.испытание": "xn--e1afmkfd.xn--80akhbyknj4f",
+		"xn--bcher-kva.ORG":  "xn--bcher-kva.org",
+	} {
+		if name, ok := NormalizeDomain(name); !ok || name != expected {
+			t.Fatalf("normalized %q (ok=%v), expected %q", name, ok, expected)
+		}
+	}
+	if _, ok := NormalizeDomain("bad\xffname.example"); ok {
+		t.Fatal("invalid UTF-8 should be rejected")
+	}
+}
+
+func TestBloomIP(t *testing.T) {
+	b := NewBloomIPDefault("ip", 1<<16, 5)
+	for _, s := range []string{"10.0.0.0/8", "192.168.1.0/22", "203.0.113.7/32", "2001:db8::/33", "::ffff:198.51.100.0/120"} {
+		if !b.AddPrefix(netip.MustParsePrefix(s)) {
+			t.Fatalf("prefix %s rejected", s)
+		}
+	}
+	b.Add(netip.MustParseAddr("fe80::1%eth0"))
+
+	for _, s := range []string{"10.255.1.2", "192.168.3.255", "192.168.0.0", "203.0.113.7", "::ffff:203.0.113.7", "2001:db8:7fff::1", "198.51.100.42", "fe80::1"} {
+		if !b.Check(netip.MustParseAddr(s)) {
+			t.Fatalf("%s should be covered", s)
+		}
+	}
+	for _, s := range []string{"11.0.0.1", "192.168.4.0", "203.0.113.8", "2001:db8:8000::1", "198.51.101.1", "::1"} {
+		if b.Check(netip.MustParseAddr(s)) {
+			t.Fatalf("%s should not be covered", s)
+		}
+	}
+
+	sparse := NewBloomIPCustom("ip", 1<<16, 5, nil, nil, [2]uint64{DefaultSeed1, DefaultSeed2})
+	if sparse.AddPrefix(netip.MustParsePrefix("10.0.0.0/8")) {
+		t.Fatal("a /8 expanded to /32 should be rejected")
+	}
+}
+
+func TestBloomGeo(t *testing.T) {
+	if h := GeoHash(57.64911, 10.40744, 11); h != "u4pruydqqvj" {
+		t.Fatalf("geohash %q, expected u4pruydqqvj", h)
+	}
+
+	b := NewBloomGeoDefault("geo", 1<<16, 5)
+	b.Add(48.8584, 2.2945)    // paris
+	b.Add(-33.8568, 151.2153) // sydney
+	b.Add(64.1466, -179.9)    // near the antimeridian
+
+	if !b.MayContainNear(48.8606, 2.3376, 5000) || !b.Check(48.8584, 2.2945) {
+		t.Fatal("point near paris not found")
+	}
+	if !b.MayContainNear(64.1466, 179.95, 20000) {
+		t.Fatal("point across the antimeridian not found")
+	}
+	if b.MayContainNear(40.7128, -74.0060, 10000) {
+		t.Fatal("no point near new york")
+	}
+	if !b.MayContainNear(0, 0, 2e7) {
+		t.Fatal("a radius beyond every precision is always possible")
+	}
+
+	for i, p := range b.GetFalsePositiveProbabilityEstimates(48.85, 2.29, 1000) {
+		if p < 0 || p > 1 {
+			t.Fatalf("estimate %f at precision %d", p, b.Precisions[i])
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := NewSetDefault("set", 100, 0.01)
+	for i := 0; i < 100; i++ {
+		s.Add(i)
+	}
+	if !s.IsExact() || s.Len() != 100 || s.Check(100) {
+		t.Fatal("a set under its threshold should be exact")
+	}
+
+	// crossing the threshold spills into a bloom without losing values
+	s.Add(100)
+	if s.IsExact() {
+		t.Fatal("the set should have spilled")
+	}
+	for i := 0; i <= 100; i++ {
+		if !s.Check(i) {
+			t.Fatalf("false negative for %d after spilling", i)
+		}
+	}
+	state, ok := s.GetState()
+	if !ok {
+		t.Fatal("a spilled set has a state")
+	}
+	if want, _ := GetOptimalParameters(101*setSpillGrowth, 0.01); state.NBits != want {
+		t.Fatalf("spilled bloom has %d bits, expected %d", state.NBits, want)
+	}
+
+	// exact into spilled, and spilled into exact
+	e := NewSetCustom("exact", 100, 0.01, true, [2]uint64{DefaultSeed1, DefaultSeed2})
+	e.Add("x")
+	if !s.Union(e) || !s.Check("x") {
+		t.Fatal("union of an exact set into a spilled set failed")
+	}
+	if !e.Union(s) || e.IsExact() || !e.Check(42) || !e.Check("x") {
+		t.Fatal("union of a spilled set into an exact set failed")
+	}
+
+	// blooms of different parameters cannot be united
+	other := NewSetDefault("other", 1, 0.001)
+	other.Add(1)
+	other.Add(2)
+	if s.Union(other) {
+		t.Fatal("union of blooms with different parameters should fail")
+	}
+
+	// a failed union leaves an exact set exact
+	seeded := NewSetCustom("seeded", 100, 0.01, false, [2]uint64{1, 2})
+	seeded.Add("y")
+	if seeded.Union(s) || !seeded.IsExact() || seeded.Len() != 1 {
+		t.Fatal("union with different seeds should fail without spilling")
+	}
+
+	// concurrent adds across the spill
+	c := NewSetCustom("concurrent", 500, 0.01, true, [2]uint64{DefaultSeed1, DefaultSeed2})
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w * 1000; i < (w+1)*1000; i++ {
+				c.Add(i)
+			}
+		}(w)
+	}
+	wg.Wait()
+	for i := 0; i < 8000; i++ {
+		if !c.Check(i) {
+			t.Fatalf("false negative for %d after concurrent adds", i)
+		}
+	}
+}
+
+func TestBloomException(t *testing.T) {
+	b := NewBloomException(NewBloomDefault("exception", 1<<10, 3))
+	for i := 0; i < 200; i++ {
+		b.Add(i)
+	}
+
+	// find a false positive and register it
+	fp := -1
+	for i := 1000; i < 100000 && fp < 0; i++ {
+		if b.Check(i) {
+			fp = i
+		}
+	}
+	if fp < 0 {
+		t.Fatal("no false positive found")
+	}
+	if !b.AddException(fp) || b.Check(fp) {
+		t.Fatal("registered false positive still reported")
+	}
+	if b.AddException("absent") {
+		t.Fatal("a value the filter rejects is not a false positive")
+	}
+
+	d := t.TempDir()
+	if err := b.Save(d); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	bl := NewBloomException(NewBloomDefault("exception", 1<<10, 3))
+	if err := bl.Load(d); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if bl.Check(fp) || !bl.Check(42) || bl.GetExceptionCount() != 1 {
+		t.Fatal("loaded filter does not match")
+	}
+	if err := NewBloomException(NewBloomDefault("exception", 1<<11, 3)).Load(d); err == nil {
+		t.Fatal("load into a filter of different parameters should fail")
+	}
+
+	// a refused load leaves the wrapped filter intact
+	other := NewBloomException(NewBloomCustom("exception", 1<<10, 3, [2]uint64{1, 2}))
+	other.Add("kept")
+	if err := other.Load(d); err == nil {
+		t.Fatal("load into a filter of different seeds should fail")
+	}
+	if !other.Check("kept") {
+		t.Fatal("refused load reset the wrapped filter")
+	}
+
+	// adding the value makes it a member again
+	b.Add(fp)
+	if !b.Check(fp) || b.GetExceptionCount() != 0 {
+		t.Fatal("added value still an exception")
+	}
+}
+
+func TestBloomKmer(t *testing.T) {
+	b := NewBloomKmerDefault("kmer", 1<<16, 4, 5)
+
+	fasta := ">chr1 test\nACGTACGGTT\nCCAGN\n>chr2\nttgacca\n"
+	n, err := b.AddReads(strings.NewReader(fasta))
+	if err != nil || n != 2 {
+		t.Fatalf("read %d records (err=%v), expected 2", n, err)
+	}
+	fastq := "@read1\nGGGCCCAAAT\n+\n@@@@@@@@@@\n"
+	if n, err = b.AddReads(strings.NewReader(fastq)); err != nil || n != 1 {
+		t.Fatalf("read %d records (err=%v), expected 1", n, err)
+	}
+
+	// k-mers spanning a line break, and reverse complements
+	for _, k := range []string{"GGTTC", "GAACC", "TTGAC", "GTCAA", "CAAAT", "ATTTG"} {
+		if !b.CheckKmer(k) {
+			t.Fatalf("k-mer %s not found", k)
+		}
+	}
+	// k-mers spanning the N or a record boundary
+	for _, k := range []string{"CCAGN", "CAGTT", "ACGTT"} {
+		if b.CheckKmer(k) {
+			t.Fatalf("unexpected k-mer %s", k)
+		}
+	}
+
+	if f := b.QueryRead([]byte("ACGTACGGTT")); f != 1 {
+		t.Fatalf("fraction %.2f for an added read, expected 1", f)
+	}
+	if f := b.QueryRead([]byte("ACGTAAAAAA")); f <= 0 || f >= 1 {
+		t.Fatalf("fraction %.2f for a partially matching read", f)
+	}
+	if b.QueryRead([]byte("ACG")) != 0 {
+		t.Fatal("a read shorter than k has no k-mer")
+	}
+}
+
+func TestBloomTyped(t *testing.T) {
+	untyped := NewBloomDefault("typed", 1<<12, 4)
+	strs := NewBloomTypedDefault[string]("typed", 1<<12, 4, StringEncoder{})
+	ints := NewBloomRWTypedDefault[uint64]("typed", 1<<12, 4, UintEncoder[uint64]{})
+	floats := NewBloomAtomicTypedDefault[float64]("typed", 1<<12, 4, Float64Encoder{})
+
+	// the built-in encoders hash like the untyped filters
+	for i := 0; i < 100; i++ {
+		untyped.Add(strconv.Itoa(i))
+		strs.Add(strconv.Itoa(i))
+	}
+	for i := uint64(0); i < 100; i++ {
+		untyped.Add(i)
+		ints.Add(i)
+		untyped.Add(float64(i) / 3)
+		floats.Add(float64(i) / 3)
+	}
+	union := NewBloomTypedDefault[string]("typed", 1<<12, 4, StringEncoder{})
+	union.Union(&strs.State)
+	union.Union(&ints.State)
+	union.Union(&floats.State)
+	if !slices.Equal(union.State.Filter, untyped.State.Filter) {
+		t.Fatal("typed filters do not match the untyped filter")
+	}
+	if !strs.Check("42") || !ints.Check(42) || !floats.Check(14) {
+		t.Fatal("false negative")
+	}
+
+	// a typed shard filter with a marshaler encoder
+	times := NewBloomShardTypedDefault[time.Time]("typed", 1<<12, 4, 8, BinaryMarshalerEncoder[time.Time]{})
+	now := time.Unix(1700000000, 0).UTC()
+	times.Add(now)
+	if !times.Check(now) {
+		t.Fatal("false negative")
+	}
+	if times.Check(now.Add(time.Second)) {
+		t.Fatal("unexpected time")
+	}
+}
+
+func TestBloomVariantsTyped(t *testing.T) {
+	// the typed variants hash like their untyped forms
+	deletable := NewBloomDeletableTypedDefault[string]("typed", 1<<12, 4, 64, StringEncoder{})
+	rotating := NewBloomRotatingTypedDefault[string]("typed", 1<<12, 4, 2, time.Hour, StringEncoder{})
+	stable := NewBloomStableTypedDefault[uint64]("typed", 1<<12, 4, 3, 2, UintEncoder[uint64]{})
+	exception := NewBloomExceptionTyped[string](NewBloomTypedDefault[string]("typed", 1<<12, 4, StringEncoder{}), StringEncoder{})
+	untyped_deletable := NewBloomDeletableDefault("typed", 1<<12, 4, 64)
+	for i := 0; i < 100; i++ {
+		deletable.Add(strconv.Itoa(i))
+		untyped_deletable.Add(strconv.Itoa(i))
+		rotating.Add(strconv.Itoa(i))
+		exception.Add(strconv.Itoa(i))
+	}
+	stable.Add(7)
+	if !slices.Equal(deletable.State.Filter, untyped_deletable.State.Filter) {
+		t.Fatal("typed deletable filter does not match the untyped filter")
+	}
+	if !deletable.Check("42") || !rotating.Check("42") || !stable.Check(7) || !exception.Check("42") {
+		t.Fatal("false negative")
+	}
+	if deletable.Remove("42") != untyped_deletable.Remove("42") || !slices.Equal(deletable.State.Filter, untyped_deletable.State.Filter) {
+		t.Fatal("typed remove does not match the untyped remove")
+	}
+	if rotating.Check("nope") {
+		t.Fatal("unexpected value")
+	}
+
+	// the exceptions are keyed by the encoder
+	for i := 100; ; i++ {
+		if exception.AddException(strconv.Itoa(i)) {
+			if exception.Check(strconv.Itoa(i)) {
+				t.Fatal("exception reported present")
+			}
+			break
+		}
+	}
+
+	// AddE and CheckE surface encoder errors
+	canonical := NewBloomRotatingTypedDefault[any]("typed", 1<<12, 4, 2, time.Hour, CanonicalEncoder{})
+	if err := canonical.AddE(make(chan int)); !errors.Is(err, ErrEncodeFailed) {
+		t.Fatalf("expected ErrEncodeFailed, got %v", err)
+	}
+	if _, err := NewBloomStableTypedDefault[any]("typed", 1<<12, 4, 3, 2, CanonicalEncoder{}).CheckE(make(chan int)); !errors.Is(err, ErrEncodeFailed) {
+		t.Fatalf("expected ErrEncodeFailed, got %v", err)
+	}
+	if err := NewBloomDeletableTypedDefault[any]("typed", 1<<12, 4, 64, CanonicalEncoder{}).AddE(make(chan int)); !errors.Is(err, ErrEncodeFailed) {
+		t.Fatalf("expected ErrEncodeFailed, got %v", err)
+	}
+}
+
+func TestCanonicalEncoder(t *testing.T) {
+	enc := CanonicalEncoder{}
+	tagged := CanonicalEncoder{TypeTags: true}
+	encode := func(e CanonicalEncoder, v any) string {
+		data, err := e.Encode(nil, v)
+		if err != nil {
+			t.Fatalf("encoding %#v: %v", v, err)
+		}
+		return string(data)
+	}
+
+	type pet struct {
+		name    string
+		friends map[string]int
+		owner   *string
+	}
+	alice, bob := "alice", "bob"
+
+	// values toBytes maps to the same bytes
+	collisions := [][2]any{
+		{int(5), uint(5)},
+		{int8(-1), uint64(math.MaxUint64)},
+		{"5", []string{"5"}},
+		{pet{name: "rex"}, pet{name: "fido"}},
+		{pet{name: "rex", owner: &alice}, pet{name: "rex", owner: &bob}},
+		{[]any{"ab", "c"}, []any{"a", "bc"}},
+	}
+	for _, c := range collisions {
+		if encode(enc, c[0]) == encode(enc, c[1]) {
+			t.Fatalf("%#v and %#v collide", c[0], c[1])
+		}
+	}
+
+	// values toBytes tells apart
+	negative_zero := math.Copysign(0, -1)
+	other_nan := math.Float64frombits(math.Float64bits(math.NaN()) + 1)
+	equal := [][2]any{
+		{0.0, negative_zero},
+		{math.NaN(), other_nan},
+		{float32(0.5), 0.5},
+		{pet{name: "rex", owner: &alice}, pet{name: "rex", owner: &[]string{"alice"}[0]}},
+		{[]byte(nil), []byte{}},
+		{int8(5), int64(5)},
+	}
+	for _, c := range equal {
+		if encode(enc, c[0]) != encode(enc, c[1]) {
+			t.Fatalf("%#v and %#v differ", c[0], c[1])
+		}
+	}
+
+	// maps are encoded in key order
+	m1, m2 := map[string]int{}, map[string]int{}
+	for i := 0; i < 100; i++ {
+		m1[strconv.Itoa(i)] = i
+		m2[strconv.Itoa(99-i)] = 99 - i
+	}
+	for i := 0; i < 10; i++ {
+		if encode(enc, pet{friends: m1}) != encode(enc, pet{friends: m2}) {
+			t.Fatal("equal maps differ")
+		}
+	}
+
+	// type tags tell apart the types of equal numbers
+	if encode(tagged, int8(5)) == encode(tagged, int64(5)) {
+		t.Fatal("type tags do not tell int8 and int64 apart")
+	}
+	if encode(tagged, 0.0) != encode(tagged, negative_zero) {
+		t.Fatal("tagged floats are not normalized")
+	}
+
+	// unsupported and cyclic values are errors, and so are marshalers behind unexported fields
+	type node struct{ next *node }
+	cycle := &node{}
+	cycle.next = cycle
+	self := []any{nil}
+	self[0] = self
+	nested := map[string]any{}
+	nested["self"] = []any{nested}
+	type event struct{ at time.Time }
+	for _, v := range []any{make(chan int), func() {}, cycle, self, nested, event{time.Unix(0, 0)}} {
+		if _, err := enc.Encode(nil, v); !errors.Is(err, ErrEncodeFailed) {
+			t.Fatalf("encoding %T: expected ErrEncodeFailed, got %v", v, err)
+		}
+	}
+
+	// shared pointers are walked once and encode like copies
+	type dag struct{ left, right *dag }
+	var shared, copied func(depth int) *dag
+	shared = func(depth int) *dag {
+		if depth == 0 {
+			return nil
+		}
+		child := shared(depth - 1)
+		return &dag{child, child}
+	}
+	copied = func(depth int) *dag {
+		if depth == 0 {
+			return nil
+		}
+		return &dag{copied(depth - 1), copied(depth - 1)}
+	}
+	if encode(enc, shared(10)) != encode(enc, copied(10)) {
+		t.Fatal("shared pointers encode differently from copies")
+	}
+	if _, err := enc.Encode(nil, shared(200)); !errors.Is(err, ErrEncodeFailed) {
+		t.Fatalf("encoding a dag of 2^200 paths: expected ErrEncodeFailed, got %v", err)
+	}
+
+	// library types use their methods, not their fields
+	type schedule struct {
+		At  time.Time
+		Loc *time.Location
+	}
+	paris := time.FixedZone("CET", 3600)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, paris)
+	before := encode(enc, schedule{at, paris})
+	_ = at.In(paris).Format(time.RFC3339)
+	if encode(enc, schedule{at, paris}) != before || encode(enc, schedule{at, time.FixedZone("CET", 3600)}) != before {
+		t.Fatal("equal schedules differ")
+	}
+	if encode(enc, schedule{at, time.UTC}) == before {
+		t.Fatal("locations are not told apart")
+	}
+}
+
+func TestBloomAddE(t *testing.T) {
+	b := NewBloomTypedDefault[any]("canonical", 1<<12, 4, CanonicalEncoder{TypeTags: true})
+	rw := NewBloomRWTypedDefault[any]("canonical", 1<<12, 4, CanonicalEncoder{})
+
+	type secret struct{ name string }
+	for _, f := range []interface {
+		AddE(any) error
+		CheckE(any) (bool, error)
+	}{b, rw, NewBloomAtomicDefault("untyped", 1<<12, 4)} {
+		if err := f.AddE(secret{"rex"}); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := f.CheckE(secret{"rex"}); !found || err != nil {
+			t.Fatalf("false negative (err=%v)", err)
+		}
+		if err := f.AddE(make(chan int)); !errors.Is(err, ErrEncodeFailed) {
+			t.Fatalf("expected ErrEncodeFailed, got %v", err)
+		}
+		if _, err := f.CheckE(func() {}); !errors.Is(err, ErrEncodeFailed) {
+			t.Fatalf("expected ErrEncodeFailed, got %v", err)
+		}
+	}
+
+	// structs with only unexported fields no longer collide
+	if found, _ := b.CheckE(secret{"fido"}); found {
+		t.Fatal("unexported fields are ignored")
+	}
+	if found, _ := rw.CheckE(secret{"fido"}); found {
+		t.Fatal("unexported fields are ignored")
+	}
+
+	// a failing marshaler is an error for the typed filters
+	shard := NewBloomShardTypedDefault[failingMarshaler]("canonical", 1<<12, 4, 8, BinaryMarshalerEncoder[failingMarshaler]{})
+	if err := shard.AddE(failingMarshaler{}); !errors.Is(err, ErrEncodeFailed) {
+		t.Fatalf("expected ErrEncodeFailed, got %v", err)
+	}
+	if _, err := shard.CheckE(failingMarshaler{}); !errors.Is(err, ErrEncodeFailed) {
+		t.Fatalf("expected ErrEncodeFailed, got %v", err)
+	}
+}
+
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalBinary() ([]byte, error) {
+	return nil, errors.New("not encodable")
+}
+
+func TestHashers(t *testing.T) {
+	// reference vectors
+	xx := xxhash64Hasher{}
+	for data, want := range map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	} {
+		if got := xx.Sum64(0, []byte(data)); got != want {
+			t.Fatalf("xxhash64(%q) = %x, expected %x", data, got, want)
+		}
+	}
+
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	msg := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}
+	if got := sipHash24(k0, k1, nil); got != 0x726fdb47dd0e0e31 {
+		t.Fatalf("siphash of the empty message = %x", got)
+	}
+	if got := sipHash24(k0, k1, msg); got != 0xa129ca6149be45e5 {
+		t.Fatalf("siphash of 15 bytes = %x", got)
+	}
+
+	f := fnv.New64a()
+	_ = binary.Write(f, binary.LittleEndian, uint64(42))
+	f.Write([]byte("hello"))
+	if got := (fnv1aHasher{}).Sum64(42, []byte("hello")); got != f.Sum64() {
+		t.Fatalf("fnv1a = %x, expected %x", got, f.Sum64())
+	}
+
+	// every hasher gives a working filter with its own indices
+	plain := NewBloomDefault("hashers", 1<<12, 4)
+	plain.Add("x")
+	for _, name := range []string{HasherMurmur3, HasherXXHash64, HasherFNV1a, HasherSipHash} {
+		b := NewBloomDefault("hashers", 1<<12, 4)
+		if err := b.State.SetHasher(name); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			b.Add(i)
+		}
+		for i := 0; i < 100; i++ {
+			if !b.Check(i) {
+				t.Fatalf("%s: false negative", name)
+			}
+		}
+
+		// only murmur3 matches the default filter
+		if b.Union(&plain.State) != (name == HasherMurmur3) {
+			t.Fatalf("%s: union with the default filter", name)
+		}
+
+		// snapshots keep the hasher
+		snapshot, err := NewBloomFromBloomDS(&b.State)
+		if err != nil || snapshot.State.Hasher != name || !snapshot.Check(42) {
+			t.Fatalf("%s: snapshot lost the hasher", name)
+		}
+	}
+
+	b := NewBloomDefault("hashers", 1<<12, 4)
+	if b.State.SetHasher("nope") == nil {
+		t.Fatal("unknown hasher accepted")
+	}
+	b.Add("x")
+	if b.State.SetHasher(HasherFNV1a) == nil {
+		t.Fatal("hasher changed on a non-empty filter")
+	}
+
+	if RegisterHasher(HasherMurmur3, murmur3Hasher{}) == nil || RegisterHasher("", xx) == nil {
+		t.Fatal("invalid registration accepted")
+	}
+	if err := RegisterHasher("test-xor", xorHasher{}); err != nil {
+		t.Fatal(err)
+	}
+	if h, found := LookupHasher("test-xor"); !found || h.Sum64(1, []byte{2}) != 3 {
+		t.Fatal("registered hasher not found")
+	}
+
+	// a secret key changes every hash, the built-in siphash24 is the zero key
+	secret := NewSipHasher(0x0123456789abcdef, 0xfedcba9876543210)
+	builtin, _ := LookupHasher(HasherSipHash)
+	if builtin.Sum64(DefaultSeed1, []byte("x")) != NewSipHasher(0, 0).Sum64(DefaultSeed1, []byte("x")) {
+		t.Fatal("built-in siphash24 is not the zero key")
+	}
+	if secret.Sum64(DefaultSeed1, []byte("x")) == builtin.Sum64(DefaultSeed1, []byte("x")) {
+		t.Fatal("the secret key does not change the hash")
+	}
+	if err := RegisterHasher("test-sip-secret", secret); err != nil {
+		t.Fatal(err)
+	}
+	keyed := NewBloomDefault("hashers", 1<<12, 4)
+	if err := keyed.State.SetHasher("test-sip-secret"); err != nil {
+		t.Fatal(err)
+	}
+	keyed.Add("x")
+	if !keyed.Check("x") {
+		t.Fatal("keyed siphash filter: false negative")
+	}
+
+	// filters on murmur3 refuse other hashers, without marking collisions
+	xxState := NewBloomDSDefault("hashers", 1<<12, 4)
+	if err := xxState.SetHasher(HasherXXHash64); err != nil {
+		t.Fatal(err)
+	}
+	xxState.Filter[0] = 1
+	d := NewBloomDeletableDefault("hashers", 1<<12, 4, 64)
+	if d.Union(&xxState) || d.GetDeletableFraction() != 1 {
+		t.Fatal("deletable union with another hasher")
+	}
+	xxDeletable := NewBloomDeletableDefault("hashers", 1<<12, 4, 64)
+	if err := xxDeletable.State.SetHasher(HasherXXHash64); err != nil {
+		t.Fatal(err)
+	}
+	if d.Merge(xxDeletable) {
+		t.Fatal("deletable merge with another hasher")
+	}
+	r := NewBloomRotatingDefault("hashers", 1<<12, 4, 3, time.Minute)
+	if r.Union(&xxState) {
+		t.Fatal("rotating union with another hasher")
+	}
+
+	// a bloom_ds built by hand resolves its hasher by name, an unknown one is refused by the
+	// constructors instead of panicking
+	hand := BloomDS{NBits: 1 << 12, NHash: 4, Filter: make([]uint64, 64), Hasher: HasherFNV1a}
+	if !slices.Equal(hand.GetIndices("x"), getIndicesWith(fnv1aHasher{}, toBytes("x"), 1<<12, 4, hand.Seeds)) {
+		t.Fatal("hand built bloom_ds does not use its hasher")
+	}
+	hand.Hasher = "nope"
+	if _, err := NewBloomFromBloomDS(&hand); err == nil {
+		t.Fatal("unknown hasher accepted by NewBloomFromBloomDS")
+	}
+	if _, err := NewBloomShardFromBloomDS(&hand, 4); err == nil {
+		t.Fatal("unknown hasher accepted by NewBloomShardFromBloomDS")
+	}
+}
+
+type xorHasher struct{}
+
+func (xorHasher) Sum64(seed uint64, data []byte) uint64 {
+	for _, c := range data {
+		seed ^= uint64(c)
+	}
+	return seed
+}
+
+func TestHasherSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	b := NewBloomDSDefault("hasher-save", 1<<10, 3)
+	if err := b.SetHasher(HasherSipHash); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewBloomFromBloomDS(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Add("x")
+	if err := f.State.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := BloomDS{ID: "hasher-save"}
+	if err := loaded.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if g, err := NewBloomFromBloomDS(&loaded); err != nil || loaded.Hasher != HasherSipHash || !g.Check("x") {
+		t.Fatal("hasher not restored")
+	}
+
+	// a gob decoded bloom_ds has no cached hasher, the snapshot resolves it by name
+	xx := NewBloomDefault("hasher-gob", 1<<12, 4)
+	if err := xx.State.SetHasher(HasherXXHash64); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		xx.Add(i)
+	}
+	var buf strings.Builder
+	if err := gob.NewEncoder(&buf).Encode(&xx.State); err != nil {
+		t.Fatal(err)
+	}
+	decoded := BloomDS{}
+	if err := gob.NewDecoder(strings.NewReader(buf.String())).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewBloomFromBloomDS(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if !g.Check(i) {
+			t.Fatalf("gob decoded xxhash64 filter: false negative for %d", i)
+		}
+	}
+
+	// a murmur3 snapshot replaces the hasher of the filter it is loaded into
+	plain := NewBloomDSDefault("hasher-plain", 1<<10, 3)
+	plain.Filter[0] = 1
+	if err := plain.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	target := NewBloomDSDefault("hasher-plain", 1<<10, 3)
+	if err := target.SetHasher(HasherXXHash64); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.Load(dir); err != nil || target.hasherName() != HasherMurmur3 || target.Filter[0] != 1 {
+		t.Fatalf("murmur3 snapshot kept hasher %q (err=%v)", target.Hasher, err)
+	}
+	if _, ok := target.getHasher().(murmur3Hasher); !ok {
+		t.Fatal("murmur3 snapshot kept the cached hasher")
+	}
+
+	// a file recorded with an unknown hasher does not load
+	loaded.Hasher = "nope"
+	if err := loaded.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&BloomDS{ID: "hasher-save"}).Load(dir); err == nil {
+		t.Fatal("unknown hasher loaded")
+	}
+
+	// variants load the hasher of their state
+	geo := NewBloomGeoDefault("hasher-geo", 1<<12, 4)
+	if err := geo.State.SetHasher(HasherXXHash64); err != nil {
+		t.Fatal(err)
+	}
+	geo.Add(48.8566, 2.3522)
+	if err := geo.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	other := NewBloomGeoDefault("hasher-geo", 1<<12, 4)
+	if err := other.Load(dir); err != nil || !other.Check(48.8566, 2.3522) {
+		t.Fatalf("variant lost the hasher (err=%v)", err)
+	}
+}
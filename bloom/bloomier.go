@@ -0,0 +1,130 @@
+package bloom
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+)
+
+// `Bloomier`: static approximate map from keys to values of ValueBits bits, built like a
+// `BinaryFuse` with the values in place of the fingerprints, so the xor of the 3 slots of a
+// key is its value, non-members get an arbitrary value
+type Bloomier struct {
+	ID                 string
+	Seeds              [2]uint64
+	Seed               uint64
+	ValueBits          uint64
+	SegmentLength      uint32
+	SegmentLengthMask  uint32
+	SegmentCount       uint32
+	SegmentCountLength uint32
+	Values             []uint64
+}
+
+// `NewBloomierDefault` return a default `Bloomier` mapping the keys of values to their values
+func NewBloomierDefault[K comparable](id string, values map[K]uint8, value_bits uint64) (*Bloomier, error) {
+	return NewBloomierCustom(id, values, value_bits, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewBloomierCustom` return a custom `Bloomier` mapping the keys of values to their values
+func NewBloomierCustom[K comparable](id string, values map[K]uint8, value_bits uint64, seeds [2]uint64) (*Bloomier, error) {
+	return NewBloomierFromSeq(id, func(yield func(any, uint8) bool) {
+		for key, value := range values {
+			if !yield(key, value) {
+				return
+			}
+		}
+	}, value_bits, seeds)
+}
+
+// `NewBloomierFromSeq` return a custom `Bloomier` built from an iterator of key-value pairs,
+// only the lowest value_bits bits (1 to 8) of each value are kept, a key given twice with
+// different values fails the construction
+func NewBloomierFromSeq(id string, pairs iter.Seq2[any, uint8], value_bits uint64, seeds [2]uint64) (*Bloomier, error) {
+
+	if value_bits < 1 || value_bits > 8 {
+		fmt.Println("NewBloomierFromSeq: value_bits not in [1,8], using default 8")
+		value_bits = 8
+	}
+	mask := uint8(1)<<value_bits - 1
+
+	// hash the keys, equal hashes must carry equal values
+	by_hash := make(map[uint64]uint8)
+	for key, value := range pairs {
+		h := hash(seeds[0], toBytes(key))
+		if old, ok := by_hash[h]; ok && old != value&mask {
+			return nil, fmt.Errorf("%w: conflicting values for the same key hash", ErrBuildFailed)
+		}
+		by_hash[h] = value & mask
+	}
+	hashes := slices.Sorted(maps.Keys(by_hash))
+
+	layout := newFuseLayout(uint32(len(hashes)))
+
+	// find a seed for which all keys peel
+	peel, err := layout.peel(hashes, seeds[1])
+	if err != nil {
+		return nil, err
+	}
+
+	filter := Bloomier{
+		ID:                 id,
+		Seeds:              seeds,
+		Seed:               peel.seed,
+		ValueBits:          value_bits,
+		SegmentLength:      layout.SegmentLength,
+		SegmentLengthMask:  layout.SegmentLengthMask,
+		SegmentCount:       layout.SegmentCount,
+		SegmentCountLength: layout.SegmentCountLength,
+		Values:             make([]uint64, packedWords(uint64(layout.arrayLength()), value_bits)),
+	}
+
+	// the peeling works on mixed hashes
+	by_mixed := make(map[uint64]uint8, len(hashes))
+	for _, h := range hashes {
+		by_mixed[mix64(h+peel.seed)] = by_hash[h]
+	}
+
+	// assign values in reverse peeling order
+	peel.assign(&layout, func(h uint64) uint64 {
+		return uint64(by_mixed[h])
+	}, func(i uint32) uint64 {
+		return getPacked(filter.Values, uint64(i), value_bits)
+	}, func(i uint32, v uint64) {
+		setPacked(filter.Values, uint64(i), value_bits, v)
+	})
+
+	return &filter, nil
+}
+
+// `Get`: value of a key, arbitrary if the key was not in the map
+func (b *Bloomier) Get(key any) uint8 {
+	h := mix64(hash(b.Seeds[0], toBytes(key)) + b.Seed)
+
+	h0, h1, h2 := b.layout().positions(h)
+	v := getPacked(b.Values, uint64(h0), b.ValueBits)
+	v ^= getPacked(b.Values, uint64(h1), b.ValueBits)
+	v ^= getPacked(b.Values, uint64(h2), b.ValueBits)
+	return uint8(v)
+}
+
+// `layout`: segment layout of the filter
+func (b *Bloomier) layout() *fuseLayout {
+	return &fuseLayout{
+		SegmentLength:      b.SegmentLength,
+		SegmentLengthMask:  b.SegmentLengthMask,
+		SegmentCount:       b.SegmentCount,
+		SegmentCountLength: b.SegmentCountLength,
+	}
+}
+
+// `Save`: save the filter to dir/id.bloomier
+func (b *Bloomier) Save(dir string) error {
+	return saveGob(dir, b.ID+".bloomier", b)
+}
+
+// `Load`: load the filter from dir/id.bloomier
+func (b *Bloomier) Load(dir string) error {
+	return loadGob(dir, b.ID+".bloomier", b)
+}
@@ -0,0 +1,265 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// largest canonical encoding, a value sharing pointers encodes every use of them in full and
+// can grow exponentially with its depth
+const canonicalMaxSize = 1 << 24
+
+// value kinds written before every canonically encoded value
+const (
+	canonicalNil       = 'n'
+	canonicalBool      = 'b'
+	canonicalInt       = 'i'
+	canonicalUint      = 'u'
+	canonicalFloat     = 'f'
+	canonicalComplex   = 'c'
+	canonicalString    = 's'
+	canonicalBytes     = 'x'
+	canonicalList      = 'l'
+	canonicalMap       = 'm'
+	canonicalStruct    = 'S'
+	canonicalMarshaler = 'B'
+)
+
+// `ErrEncodeFailed`: a key could not be encoded, returned by `AddE` and `CheckE`
+var ErrEncodeFailed = errors.New("bloom: key encoding failed")
+
+var binaryMarshalerType = reflect.TypeFor[encoding.BinaryMarshaler]()
+
+// library types whose fields hold caches, encoded by an exported view instead of their fields
+var canonicalViews = map[reflect.Type]func(v reflect.Value) []byte{
+	reflect.TypeFor[*time.Location](): func(v reflect.Value) []byte {
+		return []byte(v.Interface().(*time.Location).String())
+	},
+}
+
+// `CanonicalEncoder`: encodes any value by reflection so that equal values have equal bytes
+// and different values do not collide, integers keep their sign, -0 and +0 and all NaNs are
+// one float, map entries are sorted, unexported struct fields are included, pointers and
+// interfaces are followed and encoding.BinaryMarshaler values use their MarshalBinary
+// (a *time.Location its name), with TypeTags every value is also prefixed by its type name
+// (so int8(5) and int64(5) differ), a shared pointer is walked once but encoded like a copy,
+// channels, funcs, cyclic values, encodings over 16 MiB and marshalers behind unexported
+// fields (their method can not be called) are an error
+type CanonicalEncoder struct {
+	TypeTags bool
+}
+
+// `canonicalRef`: identity of a pointer, map or slice, a slice is also told apart by length
+type canonicalRef struct {
+	ptr    uintptr
+	typ    reflect.Type
+	length int
+}
+
+// `canonicalState`: references on the path being encoded, to find cycles, and the bytes of
+// the references already encoded, so that a shared pointer is walked once
+type canonicalState struct {
+	visiting map[canonicalRef]bool
+	encoded  map[canonicalRef][]byte
+}
+
+// `Encode`: append the canonical bytes of v to dst
+func (e CanonicalEncoder) Encode(dst []byte, v any) ([]byte, error) {
+	st := canonicalState{
+		visiting: make(map[canonicalRef]bool),
+		encoded:  make(map[canonicalRef][]byte),
+	}
+	return e.encode(dst, reflect.ValueOf(v), &st)
+}
+
+// `encode`: append the canonical bytes of a reflected value, references are encoded once
+// and a reference met again on its own path is a cycle
+func (e CanonicalEncoder) encode(dst []byte, v reflect.Value, st *canonicalState) ([]byte, error) {
+	if !v.IsValid() {
+		return append(dst, canonicalNil), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		if v.IsNil() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+			break
+		}
+		ref := canonicalRef{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			ref.length = v.Len()
+		}
+		if data, found := st.encoded[ref]; found {
+			if len(dst)+len(data) > canonicalMaxSize {
+				return dst, fmt.Errorf("%w: encoding of %s larger than %d bytes", ErrEncodeFailed, v.Type(), canonicalMaxSize)
+			}
+			return append(dst, data...), nil
+		}
+		if st.visiting[ref] {
+			return dst, fmt.Errorf("%w: cyclic value of type %s", ErrEncodeFailed, v.Type())
+		}
+
+		st.visiting[ref] = true
+		data, err := e.encodeValue(nil, v, st)
+		delete(st.visiting, ref)
+		if err != nil {
+			return dst, err
+		}
+		st.encoded[ref] = data
+		return append(dst, data...), nil
+	}
+
+	return e.encodeValue(dst, v, st)
+}
+
+// `encodeValue`: append the type tag, the kind and the contents of a value
+func (e CanonicalEncoder) encodeValue(dst []byte, v reflect.Value, st *canonicalState) ([]byte, error) {
+	if e.TypeTags {
+		dst = appendCanonicalString(dst, v.Type().String())
+	}
+
+	// library types are only encoded through their methods, never by their fields
+	kind := v.Kind()
+	nilable := kind == reflect.Pointer || kind == reflect.Interface
+	view, has_view := canonicalViews[v.Type()]
+	if (has_view || v.Type().Implements(binaryMarshalerType)) && !(nilable && v.IsNil()) {
+		if !v.CanInterface() {
+			return dst, fmt.Errorf("%w: %s behind an unexported field", ErrEncodeFailed, v.Type())
+		}
+
+		data := []byte(nil)
+		if has_view {
+			data = view(v)
+		} else {
+			var err error
+			if data, err = v.Interface().(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+				return dst, fmt.Errorf("%w: %w", ErrEncodeFailed, err)
+			}
+		}
+		dst = append(dst, canonicalMarshaler)
+		return appendCanonicalString(dst, string(data)), nil
+	}
+
+	switch kind {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(dst, canonicalBool, 1), nil
+		}
+		return append(dst, canonicalBool, 0), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		dst = append(dst, canonicalInt)
+		return binary.BigEndian.AppendUint64(dst, uint64(v.Int())), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		dst = append(dst, canonicalUint)
+		return binary.BigEndian.AppendUint64(dst, v.Uint()), nil
+
+	case reflect.Float32, reflect.Float64:
+		dst = append(dst, canonicalFloat)
+		return appendCanonicalFloat(dst, v.Float()), nil
+
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		dst = append(dst, canonicalComplex)
+		dst = appendCanonicalFloat(dst, real(c))
+		return appendCanonicalFloat(dst, imag(c)), nil
+
+	case reflect.String:
+		dst = append(dst, canonicalString)
+		return appendCanonicalString(dst, v.String()), nil
+
+	case reflect.Slice, reflect.Array:
+		// byte slices and arrays are written as a whole, a nil slice is an empty one
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			dst = append(dst, canonicalBytes)
+			dst = binary.AppendUvarint(dst, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				dst = append(dst, byte(v.Index(i).Uint()))
+			}
+			return dst, nil
+		}
+
+		dst = append(dst, canonicalList)
+		dst = binary.AppendUvarint(dst, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if dst, err = e.encode(dst, v.Index(i), st); err != nil {
+				return dst, err
+			}
+		}
+		return dst, nil
+
+	case reflect.Map:
+		// encode every entry on its own, then write them sorted by their encoded key
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := e.encode(nil, iter.Key(), st)
+			if err != nil {
+				return dst, err
+			}
+			value, err := e.encode(nil, iter.Value(), st)
+			if err != nil {
+				return dst, err
+			}
+			entries = append(entries, entry{key, value})
+		}
+		slices.SortFunc(entries, func(a, b entry) int {
+			return bytes.Compare(a.key, b.key)
+		})
+
+		dst = append(dst, canonicalMap)
+		dst = binary.AppendUvarint(dst, uint64(len(entries)))
+		for _, en := range entries {
+			dst = append(dst, en.key...)
+			dst = append(dst, en.value...)
+		}
+		return dst, nil
+
+	case reflect.Struct:
+		dst = append(dst, canonicalStruct)
+		dst = binary.AppendUvarint(dst, uint64(v.NumField()))
+		for i := 0; i < v.NumField(); i++ {
+			var err error
+			if dst, err = e.encode(dst, v.Field(i), st); err != nil {
+				return dst, err
+			}
+		}
+		return dst, nil
+
+	case reflect.Pointer, reflect.Interface:
+		// a pointer is encoded as the value it points to
+		if v.IsNil() {
+			return append(dst, canonicalNil), nil
+		}
+		return e.encode(dst, v.Elem(), st)
+	}
+
+	return dst, fmt.Errorf("%w: unsupported type %s", ErrEncodeFailed, v.Type())
+}
+
+// `appendCanonicalFloat`: append 8 big endian bytes of a float64 with -0 as +0 and every
+// NaN as the same NaN
+func appendCanonicalFloat(dst []byte, f float64) []byte {
+	switch {
+	case f == 0:
+		f = 0
+	case math.IsNaN(f):
+		f = math.NaN()
+	}
+	return binary.BigEndian.AppendUint64(dst, math.Float64bits(f))
+}
+
+// `appendCanonicalString`: append a uvarint length and the bytes of a string
+func appendCanonicalString(dst []byte, s string) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
@@ -0,0 +1,161 @@
+package bloom
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// `CountMin`: count-min sketch (Cormode & Muthukrishnan) with Depth rows of Width atomic
+// counters, row indices come from the same double hashing as `BloomDS`, with Conservative
+// set only the smallest counters are raised which tightens the overestimate
+type CountMin struct {
+	ID           string
+	Width        uint64
+	Depth        uint64
+	Seeds        [2]uint64
+	Conservative bool
+	Counters     []uint64
+	Total        uint64
+
+	rareMu sync.RWMutex
+}
+
+// `NewCountMinDefault` return a default `CountMin` object
+func NewCountMinDefault(id string, width, depth uint64) *CountMin {
+	return NewCountMinCustom(id, width, depth, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewCountMinCustom` return a custom `CountMin` object
+func NewCountMinCustom(id string, width, depth uint64, seeds [2]uint64) *CountMin {
+	cm := CountMin{
+		ID:       id,
+		Width:    width,
+		Depth:    depth,
+		Seeds:    seeds,
+		Counters: make([]uint64, width*depth),
+	}
+	return &cm
+}
+
+// `getCounters`: index of the counter of a value in every row
+func (cm *CountMin) getCounters(value any) []uint64 {
+	indices := getIndices(toBytes(value), cm.Width, cm.Depth, cm.Seeds)
+	for row := range indices {
+		indices[row] += uint64(row) * cm.Width
+	}
+	return indices
+}
+
+// `Increment`: add count occurrences of a value
+func (cm *CountMin) Increment(value any, count uint64) {
+	// unionRW mutex
+	cm.rareMu.RLock()
+	defer cm.rareMu.RUnlock()
+
+	// find the counters
+	counters := cm.getCounters(value)
+	atomic.AddUint64(&cm.Total, count)
+
+	if !cm.Conservative {
+		for _, ci := range counters {
+			atomic.AddUint64(&cm.Counters[ci], count)
+		}
+		return
+	}
+
+	// conservative update: raise every counter to at least min + count, each raise is a CAS
+	// from the value the min was computed on, so a concurrent update of one of them makes
+	// the min stale and the whole row is read again instead of losing a count
+	values := make([]uint64, len(counters))
+	for {
+		for row, ci := range counters {
+			values[row] = atomic.LoadUint64(&cm.Counters[ci])
+		}
+		target := slices.Min(values) + count
+
+		stale := false
+		for row, ci := range counters {
+			if values[row] < target && !atomic.CompareAndSwapUint64(&cm.Counters[ci], values[row], target) {
+				stale = true
+				break
+			}
+		}
+		if !stale {
+			return
+		}
+	}
+}
+
+// `Estimate`: estimated count of a value (never below the true count)
+func (cm *CountMin) Estimate(value any) uint64 {
+	// unionRW mutex
+	cm.rareMu.RLock()
+	defer cm.rareMu.RUnlock()
+
+	return cm.estimate(cm.getCounters(value))
+}
+
+// `estimate`: smallest of the counters
+func (cm *CountMin) estimate(counters []uint64) uint64 {
+	est := uint64(math.MaxUint64)
+	for _, ci := range counters {
+		est = min(est, atomic.LoadUint64(&cm.Counters[ci]))
+	}
+	return est
+}
+
+// `Merge`: add the counters of another sketch with the same width, depth and seeds
+func (cm1 *CountMin) Merge(cm2 *CountMin) bool {
+	if cm1.Width != cm2.Width || cm1.Depth != cm2.Depth || cm1.Seeds != cm2.Seeds {
+		return false
+	}
+
+	// unionRW mutex
+	cm1.rareMu.Lock()
+	defer cm1.rareMu.Unlock()
+
+	for i := range cm1.Counters {
+		cm1.Counters[i] += atomic.LoadUint64(&cm2.Counters[i])
+	}
+	cm1.Total += atomic.LoadUint64(&cm2.Total)
+	return true
+}
+
+// `Reset`: resets all counters
+func (cm *CountMin) Reset() {
+	// unionRW mutex
+	cm.rareMu.Lock()
+	defer cm.rareMu.Unlock()
+
+	for i := range cm.Counters {
+		cm.Counters[i] = 0
+	}
+	cm.Total = 0
+}
+
+// `GetErrorBound`: the overestimate that Estimate stays under with probability 1-e^-Depth
+func (cm *CountMin) GetErrorBound() float64 {
+	return math.E / float64(cm.Width) * float64(atomic.LoadUint64(&cm.Total))
+}
+
+// `GetCountMinParameters`: return (width, depth) so that estimates exceed the true count by
+// at most epsilon*Total with probability 1-delta
+func GetCountMinParameters(epsilon, delta float64) (uint64, uint64) {
+
+	if epsilon <= 0 || epsilon >= 1 {
+		fmt.Println("GetCountMinParameters: epsilon not in (0,1), using default 0.001")
+		epsilon = 0.001
+	}
+	if delta <= 0 || delta >= 1 {
+		fmt.Println("GetCountMinParameters: delta not in (0,1), using default 0.01")
+		delta = 0.01
+	}
+
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := uint64(math.Ceil(math.Log(1 / delta)))
+
+	return width, depth
+}
@@ -0,0 +1,172 @@
+package bloom
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// `KeyEncoder`: appends the bytes of a value to dst, values are hashed through these bytes
+type KeyEncoder[T any] interface {
+	Encode(dst []byte, v T) ([]byte, error)
+}
+
+// `AnyEncoder`: encodes any value like `toBytes`, the encoder of the untyped filters, it
+// is kept for compatibility and collides on values that `CanonicalEncoder` tells apart
+type AnyEncoder struct{}
+
+// `Encode`: append the bytes of `toBytes` to dst, an error where `toBytes` falls back to fmt
+func (AnyEncoder) Encode(dst []byte, v any) ([]byte, error) {
+	data, err := toBytesE(v)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, data...), nil
+}
+
+// `StringEncoder`: encodes a string as its bytes
+type StringEncoder struct{}
+
+// `Encode`: append the bytes of the string to dst
+func (StringEncoder) Encode(dst []byte, v string) ([]byte, error) {
+	return append(dst, v...), nil
+}
+
+// `BytesEncoder`: encodes a byte slice as itself
+type BytesEncoder struct{}
+
+// `Encode`: append the slice itself to dst
+func (BytesEncoder) Encode(dst []byte, v []byte) ([]byte, error) {
+	return append(dst, v...), nil
+}
+
+// `IntEncoder`: encodes a signed integer as 8 big endian bytes
+type IntEncoder[T ~int | ~int8 | ~int16 | ~int32 | ~int64] struct{}
+
+// `Encode`: append 8 big endian bytes to dst
+func (IntEncoder[T]) Encode(dst []byte, v T) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(dst, uint64(int64(v))), nil
+}
+
+// `UintEncoder`: encodes an unsigned integer as 8 big endian bytes
+type UintEncoder[T ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr] struct{}
+
+// `Encode`: append 8 big endian bytes to dst
+func (UintEncoder[T]) Encode(dst []byte, v T) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(dst, uint64(v)), nil
+}
+
+// `Float32Encoder`: encodes a float32 as its 4 big endian bytes
+type Float32Encoder struct{}
+
+// `Encode`: append 4 big endian bytes to dst
+func (Float32Encoder) Encode(dst []byte, v float32) ([]byte, error) {
+	return binary.BigEndian.AppendUint32(dst, math.Float32bits(v)), nil
+}
+
+// `Float64Encoder`: encodes a float64 as its 8 big endian bytes
+type Float64Encoder struct{}
+
+// `Encode`: append 8 big endian bytes to dst
+func (Float64Encoder) Encode(dst []byte, v float64) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(dst, math.Float64bits(v)), nil
+}
+
+// `BinaryMarshalerEncoder`: encodes a value through its MarshalBinary method
+type BinaryMarshalerEncoder[T encoding.BinaryMarshaler] struct{}
+
+// `Encode`: append the output of MarshalBinary to dst
+func (BinaryMarshalerEncoder[T]) Encode(dst []byte, v T) ([]byte, error) {
+	data, err := v.MarshalBinary()
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, data...), nil
+}
+
+// buffers reused by the typed filters so that encoding does not allocate
+var keyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 64)
+		return &buf
+	},
+}
+
+// `encodeKey`: append the bytes of a value to dst, a nil encoder uses `toBytes`, an encoder
+// error is wrapped in `ErrEncodeFailed`
+func encodeKey[T any](dst []byte, encoder KeyEncoder[T], value T) ([]byte, error) {
+	if encoder == nil {
+		return append(dst, toBytes(any(value))...), nil
+	}
+	data, err := encoder.Encode(dst, value)
+	if err != nil && !errors.Is(err, ErrEncodeFailed) {
+		err = fmt.Errorf("%w: %w", ErrEncodeFailed, err)
+	}
+	return data, err
+}
+
+// `keyString`: the bytes of a value as a map key, the fmt form of the value if the encoder
+// fails like `toBytes`
+func keyString[T any](encoder KeyEncoder[T], value T) string {
+	data, err := encodeKey(nil, encoder, value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// `hashKey`: encode a value with a pooled buffer and return its two primary hashes in
+// [0, b.NBits), a failing encoder falls back to the fmt form of the value like `toBytes`,
+// `hashKeyE` returns the error instead
+func hashKey[T any](b *BloomDS, encoder KeyEncoder[T], value T) (uint64, uint64) {
+	h1, h2, err := hashKeyE(b, encoder, value)
+	if err != nil {
+		return b.hashPair(fmt.Appendf(nil, "%v", value))
+	}
+	return h1, h2
+}
+
+// `hashKeyE`: encode a value with a pooled buffer and return its two primary hashes in
+// [0, b.NBits), an encoder error is wrapped in `ErrEncodeFailed`
+func hashKeyE[T any](b *BloomDS, encoder KeyEncoder[T], value T) (uint64, uint64, error) {
+	buf := keyBuffers.Get().(*[]byte)
+	defer keyBuffers.Put(buf)
+
+	data, err := encodeKey((*buf)[:0], encoder, value)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	h1, h2 := b.hashPair(data)
+	*buf = data[:0]
+	return h1, h2, nil
+}
+
+// `keyIndices`: encode a value with a pooled buffer and return its n_hash murmur3 indices in
+// [0, n_bits), for the filters that are not built on a bloom_ds, a failing encoder falls back
+// to the fmt form of the value
+func keyIndices[T any](encoder KeyEncoder[T], value T, n_bits, n_hash uint64, seeds [2]uint64) []uint64 {
+	indices, err := keyIndicesE(encoder, value, n_bits, n_hash, seeds)
+	if err != nil {
+		return getIndices(fmt.Appendf(nil, "%v", value), n_bits, n_hash, seeds)
+	}
+	return indices
+}
+
+// `keyIndicesE`: like `keyIndices`, an encoder error is wrapped in `ErrEncodeFailed`
+func keyIndicesE[T any](encoder KeyEncoder[T], value T, n_bits, n_hash uint64, seeds [2]uint64) ([]uint64, error) {
+	buf := keyBuffers.Get().(*[]byte)
+	defer keyBuffers.Put(buf)
+
+	data, err := encodeKey((*buf)[:0], encoder, value)
+	if err != nil {
+		return nil, err
+	}
+
+	indices := getIndices(data, n_bits, n_hash, seeds)
+	*buf = data[:0]
+	return indices, nil
+}
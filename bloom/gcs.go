@@ -0,0 +1,259 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"slices"
+)
+
+// BIP158 parameters: golomb-rice bits and inverse false positive rate
+const (
+	GCSDefaultP uint64 = 19
+	GCSDefaultM uint64 = 784931
+)
+
+// `GCS`: golomb-coded set in the style of BIP158, the keys are hashed to [0, N*M),
+// sorted, and the deltas are golomb-rice coded with P bits of remainder into Data
+type GCS struct {
+	ID   string
+	Seed uint64
+	N    uint64
+	P    uint64
+	M    uint64
+	Data []byte
+}
+
+// `NewGCSDefault` return a default `GCS` built from keys
+func NewGCSDefault(id string, keys []any) *GCS {
+	return NewGCSCustom(id, keys, GCSDefaultP, GCSDefaultM, DefaultSeed1)
+}
+
+// `NewGCSCustom` return a custom `GCS` built from keys
+func NewGCSCustom(id string, keys []any, p, m, seed uint64) *GCS {
+	// distinct keys
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = hash(seed, toBytes(key))
+	}
+	slices.Sort(hashes)
+	hashes = slices.Compact(hashes)
+
+	gcs := GCS{
+		ID:   id,
+		Seed: seed,
+		N:    uint64(len(hashes)),
+		P:    p,
+		M:    m,
+	}
+
+	// map to [0, N*M) and sort
+	for i, h := range hashes {
+		hashes[i] = gcs.reduce(h)
+	}
+	slices.Sort(hashes)
+
+	// golomb-rice code the deltas
+	w := bitWriter{}
+	last := uint64(0)
+	for _, v := range hashes {
+		delta := v - last
+		last = v
+
+		for q := delta >> p; q > 0; q-- {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		w.writeBits(delta, p)
+	}
+	gcs.Data = w.bytes
+
+	return &gcs
+}
+
+// `NewGCSFromBytes` return a `GCS` from the output of `Bytes`, p, m and seed must match the sender's
+func NewGCSFromBytes(id string, data []byte, p, m, seed uint64) (*GCS, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 {
+		return nil, errors.New("bloom: invalid gcs header")
+	}
+
+	gcs := GCS{
+		ID:   id,
+		Seed: seed,
+		N:    n,
+		P:    p,
+		M:    m,
+		Data: data[size:],
+	}
+	return &gcs, nil
+}
+
+// `Bytes`: serialize as the number of keys (uvarint) followed by the coded deltas
+func (g *GCS) Bytes() []byte {
+	return append(binary.AppendUvarint(nil, g.N), g.Data...)
+}
+
+// `reduce`: map a hash uniformly to [0, N*M)
+func (g *GCS) reduce(h uint64) uint64 {
+	hi, _ := bits.Mul64(h, g.N*g.M)
+	return hi
+}
+
+// `target`: hashed value of a key
+func (g *GCS) target(value any) uint64 {
+	return g.reduce(hash(g.Seed, toBytes(value)))
+}
+
+// `Check`: check a value to the set (false negative: never, false positives: maybe)
+func (g *GCS) Check(value any) bool {
+	if g.N == 0 {
+		return false
+	}
+
+	target := g.target(value)
+	r := bitReader{bytes: g.Data}
+	last := uint64(0)
+	for i := uint64(0); i < g.N; i++ {
+		v, ok := r.readDelta(g.P)
+		if !ok {
+			return false
+		}
+		last += v
+		if last >= target {
+			return last == target
+		}
+	}
+	return false
+}
+
+// `CheckBatch`: check many values with a single decoding pass, the result is in the order of values
+func (g *GCS) CheckBatch(values []any) []bool {
+	found := make([]bool, len(values))
+	if g.N == 0 || len(values) == 0 {
+		return found
+	}
+
+	// sort the queries by hashed value
+	order := make([]int, len(values))
+	targets := make([]uint64, len(values))
+	for i, value := range values {
+		order[i] = i
+		targets[i] = g.target(value)
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		switch {
+		case targets[a] < targets[b]:
+			return -1
+		case targets[a] > targets[b]:
+			return 1
+		}
+		return 0
+	})
+
+	// merge the sorted queries with the decoded set
+	r := bitReader{bytes: g.Data}
+	last := uint64(0)
+	qi := 0
+	for i := uint64(0); i < g.N && qi < len(order); i++ {
+		v, ok := r.readDelta(g.P)
+		if !ok {
+			break
+		}
+		last += v
+
+		for qi < len(order) && targets[order[qi]] <= last {
+			found[order[qi]] = targets[order[qi]] == last
+			qi++
+		}
+	}
+	return found
+}
+
+// `CheckAny`: check if any of the values may be in the set, with a single decoding pass
+func (g *GCS) CheckAny(values []any) bool {
+	for _, ok := range g.CheckBatch(values) {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// `GetFalsePositiveProbabilityEstimate`: false positive rate for a single value
+func (g *GCS) GetFalsePositiveProbabilityEstimate() float64 {
+	return 1 / float64(g.M)
+}
+
+// `Save`: save the filter to dir/id.gcs
+func (g *GCS) Save(dir string) error {
+	return saveGob(dir, g.ID+".gcs", g)
+}
+
+// `Load`: load the filter from dir/id.gcs
+func (g *GCS) Load(dir string) error {
+	return loadGob(dir, g.ID+".gcs", g)
+}
+
+// `bitWriter`: msb-first bit stream
+type bitWriter struct {
+	bytes []byte
+	n     uint64 // bits written
+}
+
+func (w *bitWriter) writeBit(bit uint64) {
+	if w.n%8 == 0 {
+		w.bytes = append(w.bytes, 0)
+	}
+	if bit != 0 {
+		w.bytes[len(w.bytes)-1] |= 0x80 >> (w.n % 8)
+	}
+	w.n++
+}
+
+// `writeBits`: write the lowest n bits of v, msb first
+func (w *bitWriter) writeBits(v, n uint64) {
+	for i := n; i > 0; i-- {
+		w.writeBit((v >> (i - 1)) & 1)
+	}
+}
+
+// `bitReader`: msb-first bit stream
+type bitReader struct {
+	bytes []byte
+	n     uint64 // bits read
+}
+
+func (r *bitReader) readBit() (uint64, bool) {
+	if r.n/8 >= uint64(len(r.bytes)) {
+		return 0, false
+	}
+	bit := uint64(r.bytes[r.n/8]>>(7-r.n%8)) & 1
+	r.n++
+	return bit, true
+}
+
+// `readDelta`: read a golomb-rice coded value with p bits of remainder
+func (r *bitReader) readDelta(p uint64) (uint64, bool) {
+	q := uint64(0)
+	for {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		if bit == 0 {
+			break
+		}
+		q++
+	}
+
+	v := q
+	for i := uint64(0); i < p; i++ {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		v = v<<1 | bit
+	}
+	return v, true
+}
@@ -0,0 +1,231 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+
+	"github.com/twmb/murmur3"
+)
+
+// names of the built-in hashers
+const (
+	HasherMurmur3  = "murmur3"
+	HasherXXHash64 = "xxhash64"
+	HasherFNV1a    = "fnv1a"
+	HasherSipHash  = "siphash24"
+)
+
+// `Hasher`: seeded 64-bit hash function used to derive the indices of a filter
+type Hasher interface {
+	Sum64(seed uint64, data []byte) uint64
+}
+
+var (
+	hashersMu sync.RWMutex
+	hashers   = map[string]Hasher{
+		HasherMurmur3:  murmur3Hasher{},
+		HasherXXHash64: xxhash64Hasher{},
+		HasherFNV1a:    fnv1aHasher{},
+		HasherSipHash:  sipHasher{},
+	}
+)
+
+// `RegisterHasher`: make a hasher selectable by name, an error if the name is empty or taken
+func RegisterHasher(name string, h Hasher) error {
+	if name == "" || h == nil {
+		return errors.New("bloom: hasher needs a name and a function")
+	}
+
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+
+	if _, found := hashers[name]; found {
+		return fmt.Errorf("bloom: hasher %q already registered", name)
+	}
+	hashers[name] = h
+	return nil
+}
+
+// `LookupHasher`: return the hasher registered under name, the empty name is murmur3
+func LookupHasher(name string) (Hasher, bool) {
+	if name == "" {
+		return murmur3Hasher{}, true
+	}
+
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+
+	h, found := hashers[name]
+	return h, found
+}
+
+// `murmur3Hasher`: murmur3 64-bit, the default hasher
+type murmur3Hasher struct{}
+
+func (murmur3Hasher) Sum64(seed uint64, data []byte) uint64 {
+	return murmur3.SeedSum64(seed, data)
+}
+
+// xxhash64 primes
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// `xxhash64Hasher`: XXH64 with the seed as its seed
+type xxhash64Hasher struct{}
+
+// `xxRound`: mix a lane of input into an accumulator
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+// `xxMerge`: merge an accumulator into the hash
+func xxMerge(h, acc uint64) uint64 {
+	h ^= xxRound(0, acc)
+	return h*xxPrime1 + xxPrime4
+}
+
+func (xxhash64Hasher) Sum64(seed uint64, data []byte) uint64 {
+	n := uint64(len(data))
+
+	var h uint64
+	if len(data) >= 32 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for ; len(data) >= 32; data = data[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(data[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(data[8:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(data[16:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(data[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMerge(h, v1)
+		h = xxMerge(h, v2)
+		h = xxMerge(h, v3)
+		h = xxMerge(h, v4)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += n
+
+	for ; len(data) >= 8; data = data[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(data))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		data = data[4:]
+	}
+	for _, c := range data {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	// avalanche
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+// fnv-1a 64-bit parameters
+const (
+	fnvOffset64 uint64 = 14695981039346656037
+	fnvPrime64  uint64 = 1099511628211
+)
+
+// `fnv1aHasher`: FNV-1a 64-bit over the 8 little endian bytes of the seed followed by data
+type fnv1aHasher struct{}
+
+func (fnv1aHasher) Sum64(seed uint64, data []byte) uint64 {
+	h := fnvOffset64
+	for i := 0; i < 8; i++ {
+		h ^= (seed >> (8 * i)) & 0xff
+		h *= fnvPrime64
+	}
+	for _, c := range data {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// `sipHasher`: SipHash-2-4 keyed by a secret 128-bit key mixed with the seed, the built-in
+// siphash24 has the zero key so its key only depends on the public seed
+type sipHasher struct {
+	k0, k1 uint64
+}
+
+// `NewSipHasher`: SipHash-2-4 keyed by the secret (k0, k1), register it under a name of your
+// own so that filters resist chosen-key flooding, the key is never saved with a filter so the
+// same hasher must be registered again before a saved filter is loaded
+func NewSipHasher(k0, k1 uint64) Hasher {
+	return sipHasher{k0: k0, k1: k1}
+}
+
+func (h sipHasher) Sum64(seed uint64, data []byte) uint64 {
+	return sipHash24(h.k0^seed, h.k1^mix64(seed), data)
+}
+
+// `sipHash24`: SipHash-2-4 of data with the 128-bit key (k0, k1)
+func sipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	// last block holds the remaining bytes and the length in its top byte
+	last := uint64(len(data)) << 56
+	for ; len(data) >= 8; data = data[8:] {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	for i, c := range data {
+		last |= uint64(c) << (8 * i)
+	}
+
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		round()
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
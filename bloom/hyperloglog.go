@@ -0,0 +1,254 @@
+package bloom
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// precision of the sparse representation
+	hllSparsePrecision = 25
+	// entries buffered before they are merged into the sparse list
+	hllBufferSize = 256
+)
+
+// `HyperLogLog`: HyperLogLog++ cardinality estimator, it starts with a sparse sorted list of
+// (index, rank) pairs at precision 25 and switches to 2^P dense registers once the list
+// would take more memory, dense estimates use Ertl's improved estimator
+type HyperLogLog struct {
+	ID        string
+	P         uint64
+	Seed      uint64
+	Sparse    bool
+	SparseSet []uint32
+	Registers []uint8
+
+	buffer []uint32
+}
+
+// `NewHyperLogLogDefault` return a default `HyperLogLog` with 2^p registers
+func NewHyperLogLogDefault(id string, p uint64) *HyperLogLog {
+	return NewHyperLogLogCustom(id, p, DefaultSeed1)
+}
+
+// `NewHyperLogLogCustom` return a custom `HyperLogLog` with 2^p registers
+func NewHyperLogLogCustom(id string, p, seed uint64) *HyperLogLog {
+
+	if p < 4 || p > 18 {
+		fmt.Println("NewHyperLogLogCustom: p not in [4,18], using default 14")
+		p = 14
+	}
+
+	hll := HyperLogLog{
+		ID:     id,
+		P:      p,
+		Seed:   seed,
+		Sparse: true,
+	}
+	return &hll
+}
+
+// `Add`: add a value to the set
+func (h *HyperLogLog) Add(value any) {
+	x := hash(h.Seed, toBytes(value))
+
+	if !h.Sparse {
+		h.addDense(x>>(64-h.P), hllRank(x<<h.P, 64-h.P))
+		return
+	}
+
+	// sparse entry: 25 bits of index and 6 bits of rank
+	idx := uint32(x >> (64 - hllSparsePrecision))
+	rank := uint32(hllRank(x<<hllSparsePrecision, 64-hllSparsePrecision))
+	h.buffer = append(h.buffer, idx<<6|rank)
+
+	if len(h.buffer) >= hllBufferSize {
+		h.flush()
+	}
+}
+
+// `addDense`: raise a dense register
+func (h *HyperLogLog) addDense(idx, rank uint64) {
+	if uint8(rank) > h.Registers[idx] {
+		h.Registers[idx] = uint8(rank)
+	}
+}
+
+// `flush`: merge the buffer into the sparse list, switching to dense if it grew too large
+func (h *HyperLogLog) flush() {
+	if len(h.buffer) == 0 {
+		return
+	}
+	h.SparseSet = hllMergeSparse(h.SparseSet, h.buffer)
+	h.buffer = h.buffer[:0]
+
+	// 4 bytes per sparse entry against 1 byte per dense register
+	if len(h.SparseSet)*4 > 1<<h.P {
+		h.toDense()
+	}
+}
+
+// `toDense`: switch to the dense representation
+func (h *HyperLogLog) toDense() {
+	h.Registers = make([]uint8, 1<<h.P)
+	for _, e := range h.SparseSet {
+		h.addDense(h.denseEntry(e))
+	}
+	h.Sparse = false
+	h.SparseSet = nil
+}
+
+// `denseEntry`: dense register and rank of a sparse entry
+func (h *HyperLogLog) denseEntry(e uint32) (uint64, uint64) {
+	idx := uint64(e >> 6)
+	rank := uint64(e & 63)
+
+	// the index bits below P are the start of the dense rank
+	extra := uint64(hllSparsePrecision - h.P)
+	low := idx & (uint64(1)<<extra - 1)
+	if low != 0 {
+		return idx >> extra, uint64(bits.LeadingZeros64(low)) - (64 - extra) + 1
+	}
+	return idx >> extra, extra + rank
+}
+
+// `Count`: estimated number of distinct values added
+func (h *HyperLogLog) Count() uint64 {
+	h.flush()
+
+	if h.Sparse {
+		// linear counting over the sparse registers
+		m := float64(uint64(1) << hllSparsePrecision)
+		v := m - float64(len(h.SparseSet))
+		return uint64(math.Round(m * math.Log(m/v)))
+	}
+
+	// histogram of the register values
+	q := 64 - h.P
+	c := make([]float64, q+2)
+	for _, r := range h.Registers {
+		c[r]++
+	}
+
+	m := float64(len(h.Registers))
+	z := m * hllTau((m-c[q+1])/m)
+	for k := q; k >= 1; k-- {
+		z = 0.5 * (z + c[k])
+	}
+	z += m * hllSigma(c[0]/m)
+
+	return uint64(math.Round(m * m / (2 * math.Ln2 * z)))
+}
+
+// `Merge`: merge another estimator with the same precision and seed
+func (h1 *HyperLogLog) Merge(h2 *HyperLogLog) bool {
+	if h1.P != h2.P || h1.Seed != h2.Seed {
+		return false
+	}
+	h1.flush()
+	h2.flush()
+
+	if h1.Sparse && h2.Sparse {
+		h1.buffer = append(h1.buffer, h2.SparseSet...)
+		h1.flush()
+		return true
+	}
+
+	if h1.Sparse {
+		h1.toDense()
+	}
+	if h2.Sparse {
+		for _, e := range h2.SparseSet {
+			h1.addDense(h1.denseEntry(e))
+		}
+		return true
+	}
+	for i, r := range h2.Registers {
+		h1.Registers[i] = max(h1.Registers[i], r)
+	}
+	return true
+}
+
+// `Reset`: forget all values and go back to the sparse representation
+func (h *HyperLogLog) Reset() {
+	h.Sparse = true
+	h.SparseSet = nil
+	h.Registers = nil
+	h.buffer = nil
+}
+
+// `Save`: save the estimator to dir/id.hll
+func (h *HyperLogLog) Save(dir string) error {
+	h.flush()
+	return saveGob(dir, h.ID+".hll", h)
+}
+
+// `Load`: load the estimator from dir/id.hll
+func (h *HyperLogLog) Load(dir string) error {
+	// gob leaves out zero fields (a dense Sparse=false), so decode into a fresh estimator
+	loaded := HyperLogLog{}
+	if err := loadGob(dir, h.ID+".hll", &loaded); err != nil {
+		return err
+	}
+	*h = loaded
+	return nil
+}
+
+// `hllRank`: position of the first set bit among the top n bits of w, n+1 if none
+func hllRank(w, n uint64) uint64 {
+	return min(uint64(bits.LeadingZeros64(w)), n) + 1
+}
+
+// `hllMergeSparse`: sorted union of sparse entries, keeping the highest rank per index
+func hllMergeSparse(set, entries []uint32) []uint32 {
+	merged := append(slices.Clone(set), entries...)
+	slices.Sort(merged)
+
+	// equal indices are adjacent and sorted by rank, keep the last one
+	out := merged[:0]
+	for i, e := range merged {
+		if i+1 < len(merged) && merged[i+1]>>6 == e>>6 {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// `hllSigma`: Ertl's sigma function, corrects for empty registers
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		z_old := z
+		z += x * y
+		y += y
+		if z == z_old {
+			return z
+		}
+	}
+}
+
+// `hllTau`: Ertl's tau function, corrects for saturated registers
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		z_old := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if z == z_old {
+			return z / 3
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// number of strata of an `IBLTStrata`, one per trailing zero count of the key hash
+	ibltStrataCount = 32
+	// cells of each stratum
+	ibltStrataCells = 80
+	// reconciliation rounds before giving up, the table doubles every round
+	ibltMaxRounds = 8
+)
+
+// `ErrDecodeFailed`: an invertible bloom lookup table could not be fully peeled
+var ErrDecodeFailed = errors.New("bloom: decode failed")
+
+// `IBLT`: invertible bloom lookup table (Goodrich & Mitzenmacher), every key is added to
+// one cell in each of NHash subtables, a cell keeps the number of keys (Count), the xor
+// of the keys (KeySums, KeySize bytes per cell) and the xor of their hashes (HashSums),
+// keys are stored with a uvarint length prefix so at most KeySize-1 bytes fit
+type IBLT struct {
+	ID       string
+	NCells   uint64
+	NHash    uint64
+	KeySize  uint64
+	Seeds    [2]uint64
+	Counts   []int64
+	KeySums  []byte
+	HashSums []uint64
+}
+
+// `NewIBLTDefault` return a default `IBLT` with 3 hash functions
+func NewIBLTDefault(id string, n_cells, key_size uint64) *IBLT {
+	return NewIBLTCustom(id, n_cells, 3, key_size, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewIBLTCustom` return a custom `IBLT`, n_cells is rounded up to a multiple of n_hash
+func NewIBLTCustom(id string, n_cells, n_hash, key_size uint64, seeds [2]uint64) *IBLT {
+
+	if n_hash == 0 {
+		fmt.Println("NewIBLTCustom: n_hash is 0, using default 3")
+		n_hash = 3
+	}
+	n_cells = max((n_cells+n_hash-1)/n_hash, 1) * n_hash
+
+	t := IBLT{
+		ID:       id,
+		NCells:   n_cells,
+		NHash:    n_hash,
+		KeySize:  key_size,
+		Seeds:    seeds,
+		Counts:   make([]int64, n_cells),
+		KeySums:  make([]byte, n_cells*key_size),
+		HashSums: make([]uint64, n_cells),
+	}
+	return &t
+}
+
+// `encodeKey`: key padded to KeySize bytes behind its length, false if it does not fit
+func (t *IBLT) encodeKey(key []byte) ([]byte, bool) {
+	data := binary.AppendUvarint(make([]byte, 0, t.KeySize), uint64(len(key)))
+	data = append(data, key...)
+	if uint64(len(data)) > t.KeySize {
+		return nil, false
+	}
+	return data[:t.KeySize], true
+}
+
+// `decodeKey`: key of a padded cell value, false if it is not a valid encoding
+func (t *IBLT) decodeKey(data []byte) ([]byte, bool) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(len(data)-size) {
+		return nil, false
+	}
+	end := size + int(n)
+	for _, b := range data[end:] {
+		if b != 0 {
+			return nil, false
+		}
+	}
+	return slices.Clone(data[size:end]), true
+}
+
+// `checksum`: hash of an encoded key stored in HashSums
+func (t *IBLT) checksum(data []byte) uint64 {
+	return hash(^t.Seeds[0], data)
+}
+
+// `getCells`: one cell of an encoded key in each subtable
+func (t *IBLT) getCells(data []byte) []uint64 {
+	sub := t.NCells / t.NHash
+	cells := getIndices(data, sub, t.NHash, t.Seeds)
+	for j := range cells {
+		cells[j] += uint64(j) * sub
+	}
+	return cells
+}
+
+// `update`: add an encoded key to its cells count times
+func (t *IBLT) update(data []byte, count int64) {
+	check := t.checksum(data)
+	for _, ci := range t.getCells(data) {
+		t.Counts[ci] += count
+		t.HashSums[ci] ^= check
+		xorBytes(t.KeySums[ci*t.KeySize:(ci+1)*t.KeySize], data)
+	}
+}
+
+// `Insert`: add a key to the table, false if it is longer than KeySize allows
+func (t *IBLT) Insert(key []byte) bool {
+	data, ok := t.encodeKey(key)
+	if ok {
+		t.update(data, 1)
+	}
+	return ok
+}
+
+// `Delete`: remove a key from the table, false if it is longer than KeySize allows,
+// deleting a key that was never inserted leaves it listed as removed
+func (t *IBLT) Delete(key []byte) bool {
+	data, ok := t.encodeKey(key)
+	if ok {
+		t.update(data, -1)
+	}
+	return ok
+}
+
+// `Subtract`: subtract another table of the same shape, what remains is the difference
+// of the two key sets, false if the shapes differ or either table is malformed (such as
+// a peer table whose slices do not match its cell count), t1 is unchanged then
+func (t1 *IBLT) Subtract(t2 *IBLT) bool {
+	if t2 == nil || !t1.wellFormed() || !t2.wellFormed() {
+		return false
+	}
+	if t1.NCells != t2.NCells || t1.NHash != t2.NHash || t1.KeySize != t2.KeySize || t1.Seeds != t2.Seeds {
+		return false
+	}
+	for i := range t1.Counts {
+		t1.Counts[i] -= t2.Counts[i]
+		t1.HashSums[i] ^= t2.HashSums[i]
+	}
+	xorBytes(t1.KeySums, t2.KeySums)
+	return true
+}
+
+// `ListEntries`: peel the table, return the keys with a positive count (inserted) and
+// with a negative count (deleted or only in the subtracted table), false if the table
+// could not be fully peeled, in which case the lists are partial, the table is unchanged
+func (t *IBLT) ListEntries() ([][]byte, [][]byte, bool) {
+	// peel a copy
+	p := IBLT{
+		NCells:   t.NCells,
+		NHash:    t.NHash,
+		KeySize:  t.KeySize,
+		Seeds:    t.Seeds,
+		Counts:   slices.Clone(t.Counts),
+		KeySums:  slices.Clone(t.KeySums),
+		HashSums: slices.Clone(t.HashSums),
+	}
+
+	added := make([][]byte, 0)
+	removed := make([][]byte, 0)
+
+	queue := make([]uint64, 0, t.NCells)
+	for ci := range t.NCells {
+		queue = append(queue, ci)
+	}
+	for len(queue) > 0 {
+		ci := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		count := p.Counts[ci]
+		if count != 1 && count != -1 {
+			continue
+		}
+		data := p.KeySums[ci*p.KeySize : (ci+1)*p.KeySize]
+		if p.checksum(data) != p.HashSums[ci] {
+			continue
+		}
+		key, ok := p.decodeKey(data)
+		if !ok {
+			continue
+		}
+
+		// pure cell, remove the key from all its cells
+		if count == 1 {
+			added = append(added, key)
+		} else {
+			removed = append(removed, key)
+		}
+		data = slices.Clone(data)
+		p.update(data, -count)
+		queue = append(queue, p.getCells(data)...)
+	}
+
+	return added, removed, p.isEmpty()
+}
+
+// `wellFormed`: true if the slices hold NCells cells of KeySize bytes and the cells split
+// into NHash subtables
+func (t *IBLT) wellFormed() bool {
+	if t.NHash == 0 || t.NCells%t.NHash != 0 || t.KeySize > math.MaxUint64/max(t.NCells, 1) {
+		return false
+	}
+	return uint64(len(t.Counts)) == t.NCells && uint64(len(t.HashSums)) == t.NCells && uint64(len(t.KeySums)) == t.NCells*t.KeySize
+}
+
+// `isEmpty`: true if every cell is zero
+func (t *IBLT) isEmpty() bool {
+	for i := range t.Counts {
+		if t.Counts[i] != 0 || t.HashSums[i] != 0 {
+			return false
+		}
+	}
+	for _, b := range t.KeySums {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// `Reset`: empty the table
+func (t *IBLT) Reset() {
+	clear(t.Counts)
+	clear(t.KeySums)
+	clear(t.HashSums)
+}
+
+// `Save`: save the table to dir/id.iblt
+func (t *IBLT) Save(dir string) error {
+	return saveGob(dir, t.ID+".iblt", t)
+}
+
+// `Load`: load the table from dir/id.iblt
+func (t *IBLT) Load(dir string) error {
+	return loadGob(dir, t.ID+".iblt", t)
+}
+
+// `xorBytes`: dst ^= src
+func xorBytes(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
+
+// `GetIBLTCells`: return a number of cells for a 3-hash `IBLT` that decodes n_diff
+// differences with high probability
+func GetIBLTCells(n_diff uint64) uint64 {
+	return uint64(math.Ceil(1.5*float64(n_diff))) + 24
+}
+
+// `IBLTStrata`: strata estimator (Eppstein et al.) of the size of a set difference, a key
+// goes to the stratum of the trailing zeros of its hash, so stratum i samples 2^-(i+1) of
+// the keys, and the difference is extrapolated from the strata that still decode
+type IBLTStrata struct {
+	Seeds  [2]uint64
+	Strata []*IBLT
+}
+
+// `NewIBLTStrataDefault` return a default `IBLTStrata` for keys of up to key_size-1 bytes
+func NewIBLTStrataDefault(key_size uint64) *IBLTStrata {
+	return NewIBLTStrataCustom(key_size, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewIBLTStrataCustom` return a custom `IBLTStrata` for keys of up to key_size-1 bytes
+func NewIBLTStrataCustom(key_size uint64, seeds [2]uint64) *IBLTStrata {
+	s := IBLTStrata{
+		Seeds:  seeds,
+		Strata: make([]*IBLT, ibltStrataCount),
+	}
+	for i := range s.Strata {
+		s.Strata[i] = NewIBLTCustom("", ibltStrataCells, 3, key_size, seeds)
+	}
+	return &s
+}
+
+// `Insert`: add a key to its stratum, false if it is longer than the key size allows
+func (s *IBLTStrata) Insert(key []byte) bool {
+	i := min(bits.TrailingZeros64(hash(^s.Seeds[1], key)), ibltStrataCount-1)
+	return s.Strata[i].Insert(key)
+}
+
+// `Estimate`: estimated size of the symmetric difference with another estimator, 0 if the
+// estimators are incompatible or s2 is malformed
+func (s1 *IBLTStrata) Estimate(s2 *IBLTStrata) uint64 {
+	if s1.Seeds != s2.Seeds || len(s1.Strata) != len(s2.Strata) {
+		return 0
+	}
+
+	// decode from the sparsest stratum down, extrapolate at the first failure
+	count := uint64(0)
+	for i := len(s1.Strata) - 1; i >= 0; i-- {
+		diff := *s1.Strata[i]
+		diff.Counts = slices.Clone(diff.Counts)
+		diff.KeySums = slices.Clone(diff.KeySums)
+		diff.HashSums = slices.Clone(diff.HashSums)
+		if !diff.Subtract(s2.Strata[i]) {
+			return 0
+		}
+
+		added, removed, ok := diff.ListEntries()
+		if !ok {
+			return count << (i + 1)
+		}
+		count += uint64(len(added) + len(removed))
+	}
+	return count
+}
+
+// `ReconcileIBLT`: run a symmetric reconciliation round with a peer doing the same over rw,
+// the peers exchange strata estimators, then tables sized for the estimate, doubling the size
+// while the difference does not decode, return the keys only held locally and only held by
+// the peer, keys must be shorter than key_size bytes, if rw is an io.Closer it is closed when
+// receiving from the peer fails so that the pending send can not block forever
+func ReconcileIBLT(rw io.ReadWriter, keys [][]byte, key_size uint64) ([][]byte, [][]byte, error) {
+	enc := gob.NewEncoder(rw)
+	dec := gob.NewDecoder(rw)
+
+	// estimate the size of the difference, both peers get the same estimate
+	local_strata := NewIBLTStrataDefault(key_size)
+	for _, key := range keys {
+		if !local_strata.Insert(key) {
+			return nil, nil, fmt.Errorf("bloom: key of %d bytes does not fit key size %d", len(key), key_size)
+		}
+	}
+	remote_strata := IBLTStrata{}
+	if err := exchangeGob(rw, enc, dec, local_strata, &remote_strata); err != nil {
+		return nil, nil, err
+	}
+	n_cells := GetIBLTCells(local_strata.Estimate(&remote_strata))
+
+	for round := 0; round < ibltMaxRounds; round++ {
+		local := NewIBLTDefault("", n_cells, key_size)
+		for _, key := range keys {
+			local.Insert(key)
+		}
+		remote := IBLT{}
+		if err := exchangeGob(rw, enc, dec, local, &remote); err != nil {
+			return nil, nil, err
+		}
+		if !local.Subtract(&remote) {
+			return nil, nil, errors.New("bloom: peer table does not match the local table")
+		}
+
+		// peeling is symmetric, both peers fail or succeed together
+		only_local, only_remote, ok := local.ListEntries()
+		if ok {
+			return only_local, only_remote, nil
+		}
+		n_cells *= 2
+	}
+
+	return nil, nil, fmt.Errorf("%w: no reconciliation after %d rounds", ErrDecodeFailed, ibltMaxRounds)
+}
+
+// `exchangeGob`: send v while receiving the peer's value into out, the send is waited for on
+// every path, after closing rw (if it can be closed) when the receive fails
+func exchangeGob(rw io.ReadWriter, enc *gob.Encoder, dec *gob.Decoder, v, out any) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- enc.Encode(v)
+	}()
+
+	if err := dec.Decode(out); err != nil {
+		if c, ok := rw.(io.Closer); ok {
+			c.Close()
+		}
+		<-errc
+		return err
+	}
+	return <-errc
+}
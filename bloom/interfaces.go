@@ -1,9 +1,11 @@
 package bloom
 
-type IBloom interface {
-	Add(any)
-	Check(any) bool
+type IBloomOf[T any] interface {
+	Add(T)
+	Check(T) bool
 	Reset()
 	Union(*BloomDS) bool
 	GetState() BloomDS
 }
+
+type IBloom = IBloomOf[any]
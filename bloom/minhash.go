@@ -0,0 +1,205 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+)
+
+// `MinHash`: minhash signature of a set, entry i is the minimum of the i-th hash function
+// over all values added, the fraction of equal entries estimates the jaccard similarity
+type MinHash struct {
+	ID        string
+	NHash     uint64
+	Seeds     [2]uint64
+	Signature []uint64
+}
+
+// `NewMinHashDefault` return a default `MinHash` with n_hash hash functions
+func NewMinHashDefault(id string, n_hash uint64) *MinHash {
+	return NewMinHashCustom(id, n_hash, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewMinHashCustom` return a custom `MinHash` with n_hash hash functions
+func NewMinHashCustom(id string, n_hash uint64, seeds [2]uint64) *MinHash {
+	m := MinHash{
+		ID:        id,
+		NHash:     n_hash,
+		Seeds:     seeds,
+		Signature: make([]uint64, n_hash),
+	}
+	m.Reset()
+	return &m
+}
+
+// `Add`: add a value to the set
+func (m *MinHash) Add(value any) {
+	data := toBytes(value)
+
+	// the i-th hash function is a mix of h1 + i*h2
+	h1 := hash(m.Seeds[0], data)
+	h2 := hash(m.Seeds[1], data)
+	for i := range m.Signature {
+		m.Signature[i] = min(m.Signature[i], mix64(h1+uint64(i)*h2))
+	}
+}
+
+// `Reset`: forget all values
+func (m *MinHash) Reset() {
+	for i := range m.Signature {
+		m.Signature[i] = math.MaxUint64
+	}
+}
+
+// `Merge`: turn the signature into the signature of the union with another set
+func (m1 *MinHash) Merge(m2 *MinHash) bool {
+	if m1.NHash != m2.NHash || m1.Seeds != m2.Seeds {
+		return false
+	}
+	for i := range m1.Signature {
+		m1.Signature[i] = min(m1.Signature[i], m2.Signature[i])
+	}
+	return true
+}
+
+// `Similarity`: estimated jaccard similarity with another set, 0 if the signatures are incompatible
+func (m1 *MinHash) Similarity(m2 *MinHash) float64 {
+	if m1.NHash != m2.NHash || m1.Seeds != m2.Seeds || m1.NHash == 0 {
+		return 0
+	}
+
+	equal := 0
+	for i := range m1.Signature {
+		if m1.Signature[i] == m2.Signature[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(m1.NHash)
+}
+
+// `BBit`: return the b-bit minhash of the signature, keeping the lowest b bits of each entry
+func (m *MinHash) BBit(b uint64) *MinHashBBit {
+	b = min(max(b, 1), 64)
+	bb := MinHashBBit{
+		ID:     m.ID,
+		B:      b,
+		NHash:  m.NHash,
+		Seeds:  m.Seeds,
+		Values: make([]uint64, packedWords(m.NHash, b)),
+	}
+	for i, v := range m.Signature {
+		setPacked(bb.Values, uint64(i), b, v)
+	}
+	return &bb
+}
+
+// `MinHashBBit`: b-bit minhash (Li & König), NHash entries of B bits packed in Values
+type MinHashBBit struct {
+	ID     string
+	B      uint64
+	NHash  uint64
+	Seeds  [2]uint64
+	Values []uint64
+}
+
+// `Similarity`: estimated jaccard similarity, corrected for the 2^-B chance of equal low bits
+func (m1 *MinHashBBit) Similarity(m2 *MinHashBBit) float64 {
+	if m1.B != m2.B || m1.NHash != m2.NHash || m1.Seeds != m2.Seeds || m1.NHash == 0 {
+		return 0
+	}
+
+	equal := 0
+	for i := uint64(0); i < m1.NHash; i++ {
+		if getPacked(m1.Values, i, m1.B) == getPacked(m2.Values, i, m2.B) {
+			equal++
+		}
+	}
+
+	// chance agreement of two unrelated entries
+	c := math.Exp2(-float64(m1.B))
+	j := (float64(equal)/float64(m1.NHash) - c) / (1 - c)
+	return min(max(j, 0), 1)
+}
+
+// `MinHashLSH`: banding index over minhash signatures, each signature is split into Bands
+// bands of Rows entries and sets sharing any band become candidates of each other
+type MinHashLSH struct {
+	Bands uint64
+	Rows  uint64
+
+	buckets []map[uint64][]string
+}
+
+// `NewMinHashLSH` return an empty index with bands bands of rows entries
+func NewMinHashLSH(bands, rows uint64) *MinHashLSH {
+	lsh := MinHashLSH{
+		Bands:   bands,
+		Rows:    rows,
+		buckets: make([]map[uint64][]string, bands),
+	}
+	for i := range lsh.buckets {
+		lsh.buckets[i] = make(map[uint64][]string)
+	}
+	return &lsh
+}
+
+// `NewMinHashLSHThreshold` return an empty index tuned for signatures of n_hash entries
+// and a jaccard similarity threshold
+func NewMinHashLSHThreshold(n_hash uint64, threshold float64) *MinHashLSH {
+	return NewMinHashLSH(GetLSHParameters(n_hash, threshold))
+}
+
+// `bandKeys`: hash of each band of a signature
+func (lsh *MinHashLSH) bandKeys(m *MinHash) []uint64 {
+	keys := make([]uint64, lsh.Bands)
+	buf := make([]byte, 8*lsh.Rows)
+	for band := uint64(0); band < lsh.Bands; band++ {
+		for r := uint64(0); r < lsh.Rows; r++ {
+			binary.BigEndian.PutUint64(buf[8*r:], m.Signature[band*lsh.Rows+r])
+		}
+		keys[band] = hash(band, buf)
+	}
+	return keys
+}
+
+// `Insert`: index a signature under id, false if it has less than Bands*Rows entries
+func (lsh *MinHashLSH) Insert(id string, m *MinHash) bool {
+	if m.NHash < lsh.Bands*lsh.Rows {
+		return false
+	}
+	for band, key := range lsh.bandKeys(m) {
+		lsh.buckets[band][key] = append(lsh.buckets[band][key], id)
+	}
+	return true
+}
+
+// `Query`: ids of the indexed signatures sharing at least one band with m
+func (lsh *MinHashLSH) Query(m *MinHash) []string {
+	if m.NHash < lsh.Bands*lsh.Rows {
+		return nil
+	}
+
+	candidates := make([]string, 0)
+	for band, key := range lsh.bandKeys(m) {
+		candidates = append(candidates, lsh.buckets[band][key]...)
+	}
+	slices.Sort(candidates)
+	return slices.Compact(candidates)
+}
+
+// `GetLSHParameters`: return (bands, rows) with bands*rows <= n_hash whose similarity
+// threshold (1/bands)^(1/rows) is closest to threshold
+func GetLSHParameters(n_hash uint64, threshold float64) (uint64, uint64) {
+	best_bands, best_rows := uint64(1), max(n_hash, 1)
+	best := math.Inf(1)
+
+	for rows := uint64(1); rows <= n_hash; rows++ {
+		bands := n_hash / rows
+		t := math.Pow(1/float64(bands), 1/float64(rows))
+		if d := math.Abs(t - threshold); d < best {
+			best = d
+			best_bands, best_rows = bands, rows
+		}
+	}
+	return best_bands, best_rows
+}
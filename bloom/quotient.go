@@ -0,0 +1,455 @@
+package bloom
+
+import (
+	"fmt"
+	"math"
+)
+
+// maximum load factor before Add doubles the filter
+const QuotientMaxLoad = 0.75
+
+// slot metadata bits, stored below the remainder
+const (
+	qfOccupied     uint64 = 1
+	qfContinuation uint64 = 2
+	qfShifted      uint64 = 4
+)
+
+// `QuotientFilter`: quotient filter (Bender et al.) storing (QBits+RBits)-bit fingerprints
+// as sorted runs of RBits remainders, it supports Delete, Union and Double without the keys
+type QuotientFilter struct {
+	ID       string
+	QBits    uint64
+	RBits    uint64
+	Seed     uint64
+	NEntries uint64
+	Slots    []uint64
+}
+
+// `NewQuotientFilterDefault` return a default `QuotientFilter` with 2^q_bits slots
+func NewQuotientFilterDefault(id string, q_bits, r_bits uint64) *QuotientFilter {
+	return NewQuotientFilterCustom(id, q_bits, r_bits, DefaultSeed1)
+}
+
+// `NewQuotientFilterCustom` return a custom `QuotientFilter` with 2^q_bits slots
+func NewQuotientFilterCustom(id string, q_bits, r_bits, seed uint64) *QuotientFilter {
+
+	// a slot holds r_bits plus 3 metadata bits and must fit in a word
+	if r_bits < 1 || r_bits > 61 || q_bits < 1 || q_bits+r_bits > 64 {
+		fmt.Println("NewQuotientFilterCustom: need q_bits >= 1, r_bits in [1,61] and q_bits+r_bits <= 64, using default 16, 8")
+		q_bits, r_bits = 16, 8
+	}
+
+	qf := QuotientFilter{
+		ID:    id,
+		QBits: q_bits,
+		RBits: r_bits,
+		Seed:  seed,
+		Slots: make([]uint64, packedWords(uint64(1)<<q_bits, r_bits+3)),
+	}
+	return &qf
+}
+
+// `size`: number of slots
+func (qf *QuotientFilter) size() uint64 {
+	return uint64(1) << qf.QBits
+}
+
+// `get`: slot i, remainder and metadata bits
+func (qf *QuotientFilter) get(i uint64) uint64 {
+	return getPacked(qf.Slots, i, qf.RBits+3)
+}
+
+// `set`: overwrite slot i
+func (qf *QuotientFilter) set(i, elt uint64) {
+	setPacked(qf.Slots, i, qf.RBits+3, elt)
+}
+
+// `incr`: next slot, wrapping around
+func (qf *QuotientFilter) incr(i uint64) uint64 {
+	return (i + 1) & (qf.size() - 1)
+}
+
+// `decr`: previous slot, wrapping around
+func (qf *QuotientFilter) decr(i uint64) uint64 {
+	return (i - 1) & (qf.size() - 1)
+}
+
+// `fingerprint`: (QBits+RBits)-bit fingerprint of a value
+func (qf *QuotientFilter) fingerprint(value any) uint64 {
+	fp := hash(qf.Seed, toBytes(value))
+	if bits := qf.QBits + qf.RBits; bits < 64 {
+		fp &= uint64(1)<<bits - 1
+	}
+	return fp
+}
+
+// `split`: return the quotient and remainder of a fingerprint
+func (qf *QuotientFilter) split(fp uint64) (uint64, uint64) {
+	return fp >> qf.RBits, fp & (uint64(1)<<qf.RBits - 1)
+}
+
+func qfIsEmpty(elt uint64) bool {
+	return elt&7 == 0
+}
+
+func qfIsClusterStart(elt uint64) bool {
+	return elt&qfOccupied != 0 && elt&qfContinuation == 0 && elt&qfShifted == 0
+}
+
+func qfIsRunStart(elt uint64) bool {
+	return elt&qfContinuation == 0 && (elt&qfOccupied != 0 || elt&qfShifted != 0)
+}
+
+// `findRun`: slot where the run of quotient fq starts (or would start)
+func (qf *QuotientFilter) findRun(fq uint64) uint64 {
+	// walk back to the start of the cluster
+	b := fq
+	for qf.get(b)&qfShifted != 0 {
+		b = qf.decr(b)
+	}
+
+	// walk forward one run per occupied quotient
+	s := b
+	for b != fq {
+		for {
+			s = qf.incr(s)
+			if qf.get(s)&qfContinuation == 0 {
+				break
+			}
+		}
+		for {
+			b = qf.incr(b)
+			if qf.get(b)&qfOccupied != 0 {
+				break
+			}
+		}
+	}
+	return s
+}
+
+// `insertAt`: write elt into slot s, shifting the following slots right
+func (qf *QuotientFilter) insertAt(s, elt uint64) {
+	curr := elt
+	for {
+		prev := qf.get(s)
+		empty := qfIsEmpty(prev)
+		if !empty {
+			// the occupied bit belongs to the slot, not to the entry
+			prev |= qfShifted
+			if prev&qfOccupied != 0 {
+				curr |= qfOccupied
+				prev &^= qfOccupied
+			}
+		}
+		qf.set(s, curr)
+		curr = prev
+		s = qf.incr(s)
+		if empty {
+			return
+		}
+	}
+}
+
+// `insert`: add a fingerprint, return false when the filter is full
+func (qf *QuotientFilter) insert(fp uint64) bool {
+	if qf.NEntries >= qf.size() {
+		return false
+	}
+
+	fq, fr := qf.split(fp)
+	t_fq := qf.get(fq)
+	entry := fr << 3
+
+	// the canonical slot is free
+	if qfIsEmpty(t_fq) {
+		qf.set(fq, entry|qfOccupied)
+		qf.NEntries++
+		return true
+	}
+
+	if t_fq&qfOccupied == 0 {
+		qf.set(fq, t_fq|qfOccupied)
+	}
+
+	start := qf.findRun(fq)
+	s := start
+
+	if t_fq&qfOccupied != 0 {
+		// find the position of the remainder in the sorted run
+		for {
+			rem := qf.get(s) >> 3
+			if rem == fr {
+				return true
+			} else if rem > fr {
+				break
+			}
+			s = qf.incr(s)
+			if qf.get(s)&qfContinuation == 0 {
+				break
+			}
+		}
+
+		if s == start {
+			// the old head of the run becomes a continuation
+			qf.set(start, qf.get(start)|qfContinuation)
+		} else {
+			entry |= qfContinuation
+		}
+	}
+
+	if s != fq {
+		entry |= qfShifted
+	}
+
+	qf.insertAt(s, entry)
+	qf.NEntries++
+	return true
+}
+
+// `contains`: check a fingerprint
+func (qf *QuotientFilter) contains(fp uint64) bool {
+	fq, fr := qf.split(fp)
+	if qf.get(fq)&qfOccupied == 0 {
+		return false
+	}
+
+	// scan the sorted run for the remainder
+	s := qf.findRun(fq)
+	for {
+		rem := qf.get(s) >> 3
+		if rem == fr {
+			return true
+		} else if rem > fr {
+			return false
+		}
+		s = qf.incr(s)
+		if qf.get(s)&qfContinuation == 0 {
+			return false
+		}
+	}
+}
+
+// `deleteEntry`: remove slot s, shifting the rest of the cluster left
+func (qf *QuotientFilter) deleteEntry(s, quot uint64) {
+	curr := qf.get(s)
+	sp := qf.incr(s)
+	orig := s
+
+	for {
+		next := qf.get(sp)
+		curr_occupied := curr&qfOccupied != 0
+
+		if qfIsEmpty(next) || qfIsClusterStart(next) || sp == orig {
+			qf.set(s, 0)
+			return
+		}
+
+		// entries sliding into their canonical slot are no longer shifted
+		updated := next
+		if qfIsRunStart(next) {
+			for {
+				quot = qf.incr(quot)
+				if qf.get(quot)&qfOccupied != 0 {
+					break
+				}
+			}
+			if curr_occupied && quot == s {
+				updated &^= qfShifted
+			}
+		}
+
+		// keep the occupied bit of slot s
+		if curr_occupied {
+			updated |= qfOccupied
+		} else {
+			updated &^= qfOccupied
+		}
+		qf.set(s, updated)
+
+		s = sp
+		sp = qf.incr(sp)
+		curr = next
+	}
+}
+
+// `remove`: delete a fingerprint, return false if it was not present
+func (qf *QuotientFilter) remove(fp uint64) bool {
+	fq, fr := qf.split(fp)
+	t_fq := qf.get(fq)
+
+	if t_fq&qfOccupied == 0 || qf.NEntries == 0 {
+		return false
+	}
+
+	// find the remainder in the sorted run
+	start := qf.findRun(fq)
+	s := start
+	for {
+		rem := qf.get(s) >> 3
+		if rem == fr {
+			break
+		} else if rem > fr {
+			return false
+		}
+		s = qf.incr(s)
+		if qf.get(s)&qfContinuation == 0 {
+			return false
+		}
+	}
+
+	kill := qf.get(s)
+	replace_run_start := qfIsRunStart(kill)
+
+	// deleting the only entry of the run clears the occupied bit
+	if replace_run_start && qf.get(qf.incr(s))&qfContinuation == 0 {
+		qf.set(fq, qf.get(fq)&^qfOccupied)
+	}
+
+	qf.deleteEntry(s, fq)
+
+	if replace_run_start {
+		next := qf.get(s)
+		updated := next
+		if updated&qfContinuation != 0 {
+			// the new head of the run is no longer a continuation
+			updated &^= qfContinuation
+		}
+		if s == fq && qfIsRunStart(updated) {
+			// the new head of the run is in its canonical slot
+			updated &^= qfShifted
+		}
+		if updated != next {
+			qf.set(s, updated)
+		}
+	}
+
+	qf.NEntries--
+	return true
+}
+
+// `fingerprints`: return all stored fingerprints
+func (qf *QuotientFilter) fingerprints() []uint64 {
+	fps := make([]uint64, 0, qf.NEntries)
+	if qf.NEntries == 0 {
+		return fps
+	}
+
+	// start at the beginning of a cluster, searching with wraparound
+	start := uint64(0)
+	for n := uint64(0); n < qf.size() && !qfIsClusterStart(qf.get(start)); n++ {
+		start = qf.incr(start)
+	}
+
+	quot := uint64(0)
+	for i, visited := start, uint64(0); visited < qf.NEntries; i = qf.incr(i) {
+		elt := qf.get(i)
+
+		// keep track of the quotient of the current run
+		if qfIsClusterStart(elt) {
+			quot = i
+		} else if qfIsRunStart(elt) {
+			for {
+				quot = qf.incr(quot)
+				if qf.get(quot)&qfOccupied != 0 {
+					break
+				}
+			}
+		}
+
+		if !qfIsEmpty(elt) {
+			fps = append(fps, quot<<qf.RBits|elt>>3)
+			visited++
+		}
+	}
+	return fps
+}
+
+// `Add`: add a value to the set, doubling the filter when it is too full,
+// returns false when the filter is full and can not double anymore
+func (qf *QuotientFilter) Add(value any) bool {
+	if float64(qf.NEntries+1) > QuotientMaxLoad*float64(qf.size()) {
+		qf.Double()
+	}
+	return qf.insert(qf.fingerprint(value))
+}
+
+// `Check`: check a value to the set (false negative: never, false positives: maybe)
+func (qf *QuotientFilter) Check(value any) bool {
+	return qf.contains(qf.fingerprint(value))
+}
+
+// `Delete`: delete a value from the set, return false if it was not present,
+// deleting a value that was never added can remove a colliding value
+func (qf *QuotientFilter) Delete(value any) bool {
+	return qf.remove(qf.fingerprint(value))
+}
+
+// `Reset`: resets all slots
+func (qf *QuotientFilter) Reset() {
+	for i := range qf.Slots {
+		qf.Slots[i] = 0
+	}
+	qf.NEntries = 0
+}
+
+// `Double`: double the number of slots by moving one remainder bit into the quotient,
+// return false when there is a single remainder bit left
+func (qf *QuotientFilter) Double() bool {
+	if qf.RBits <= 1 {
+		return false
+	}
+
+	bigger := NewQuotientFilterCustom(qf.ID, qf.QBits+1, qf.RBits-1, qf.Seed)
+	for _, fp := range qf.fingerprints() {
+		bigger.insert(fp)
+	}
+	*qf = *bigger
+	return true
+}
+
+// `Union`: merge another quotient filter with the same seed and fingerprint size,
+// doubling as needed to fit both
+func (qf1 *QuotientFilter) Union(qf2 *QuotientFilter) bool {
+	if qf1.Seed != qf2.Seed || qf1.QBits+qf1.RBits != qf2.QBits+qf2.RBits {
+		return false
+	}
+
+	// pick the number of slots for the merged filter
+	q_bits := max(qf1.QBits, qf2.QBits)
+	r_bits := qf1.QBits + qf1.RBits - q_bits
+	n := qf1.NEntries + qf2.NEntries
+	for float64(n) > QuotientMaxLoad*float64(uint64(1)<<q_bits) && r_bits > 1 {
+		q_bits++
+		r_bits--
+	}
+	if n > uint64(1)<<q_bits {
+		return false
+	}
+
+	merged := NewQuotientFilterCustom(qf1.ID, q_bits, r_bits, qf1.Seed)
+	for _, fp := range qf1.fingerprints() {
+		merged.insert(fp)
+	}
+	for _, fp := range qf2.fingerprints() {
+		merged.insert(fp)
+	}
+	*qf1 = *merged
+	return true
+}
+
+// `GetFalsePositiveProbabilityEstimate`: false positive rate at the current load factor
+func (qf *QuotientFilter) GetFalsePositiveProbabilityEstimate() float64 {
+	load := float64(qf.NEntries) / float64(qf.size())
+	return 1 - math.Exp(-load/math.Exp2(float64(qf.RBits)))
+}
+
+// `Save`: save the filter to dir/id.qf
+func (qf *QuotientFilter) Save(dir string) error {
+	return saveGob(dir, qf.ID+".qf", qf)
+}
+
+// `Load`: load the filter from dir/id.qf
+func (qf *QuotientFilter) Load(dir string) error {
+	return loadGob(dir, qf.ID+".qf", qf)
+}
@@ -0,0 +1,283 @@
+package bloom
+
+import (
+	"fmt"
+	"iter"
+	"math"
+	"math/bits"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+const (
+	// width of a ribbon row, in slots
+	ribbonWidth = 64
+	// keys per independently built shard
+	ribbonShardKeys = 1 << 16
+	// keys hashed per batch while reading the iterator
+	ribbonBatchKeys = 4096
+	// seeds tried per shard before giving up on a construction
+	ribbonMaxIterations = 64
+	// initial space overhead, grown after repeated failures
+	ribbonOverhead = 0.08
+)
+
+// `Ribbon`: static standard ribbon filter (Dillinger & Walzer), each key is a row of a
+// banded linear system over GF(2) with RBits result bits, solved by on-the-fly gaussian
+// elimination, keys are split into shards that are built in parallel
+type Ribbon struct {
+	ID          string
+	Seeds       [2]uint64
+	RBits       uint64
+	NShards     uint64
+	ShardSeeds  []uint64
+	ShardStarts []uint64
+	Solution    []uint64
+}
+
+// `ribbonRow`: start slot, coefficients and expected result of a key in its shard
+type ribbonRow struct {
+	start  uint64
+	coeff  uint64
+	result uint64
+}
+
+// `NewRibbonDefault` return a default `Ribbon` built from keys
+func NewRibbonDefault(id string, keys []any, prob_fp float64) (*Ribbon, error) {
+	return NewRibbonCustom(id, keys, prob_fp, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewRibbonCustom` return a custom `Ribbon` built from keys
+func NewRibbonCustom(id string, keys []any, prob_fp float64, seeds [2]uint64) (*Ribbon, error) {
+	return NewRibbonFromSeq(id, slices.Values(keys), prob_fp, seeds)
+}
+
+// `NewRibbonFromSeq` return a custom `Ribbon` built from an iterator of keys,
+// hashing and solving are spread over GOMAXPROCS goroutines
+func NewRibbonFromSeq(id string, keys iter.Seq[any], prob_fp float64, seeds [2]uint64) (*Ribbon, error) {
+
+	if prob_fp <= 0 || prob_fp >= 1 {
+		fmt.Println("NewRibbonFromSeq: prob_fp not in (0,1), using default 0.01")
+		prob_fp = 0.01
+	}
+
+	filter := Ribbon{
+		ID:    id,
+		Seeds: seeds,
+		RBits: min(max(uint64(math.Ceil(-math.Log2(prob_fp))), 1), 32),
+	}
+
+	// hash the keys in parallel, duplicates are solved once
+	hashes := filter.hashKeys(keys)
+	slices.Sort(hashes)
+	hashes = slices.Compact(hashes)
+
+	// split the keys into shards
+	filter.NShards = max(uint64(len(hashes))/ribbonShardKeys, 1)
+	shards := make([][]uint64, filter.NShards)
+	for _, h := range hashes {
+		si := filter.shard(h)
+		shards[si] = append(shards[si], h)
+	}
+
+	// solve the shards in parallel
+	solutions := make([][]uint64, filter.NShards)
+	filter.ShardSeeds = make([]uint64, filter.NShards)
+	errs := make([]error, filter.NShards)
+
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for si := range shards {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(si int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			filter.ShardSeeds[si], solutions[si], errs[si] = filter.solveShard(shards[si], uint64(si))
+		}(si)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// pack the solutions of all shards
+	filter.ShardStarts = make([]uint64, filter.NShards+1)
+	for si, z := range solutions {
+		filter.ShardStarts[si+1] = filter.ShardStarts[si] + uint64(len(z))
+	}
+	filter.Solution = make([]uint64, packedWords(filter.ShardStarts[filter.NShards], filter.RBits))
+	for si, z := range solutions {
+		for i, v := range z {
+			setPacked(filter.Solution, filter.ShardStarts[si]+uint64(i), filter.RBits, v)
+		}
+	}
+
+	return &filter, nil
+}
+
+// `hashKeys`: hash keys from the iterator in parallel batches
+func (b *Ribbon) hashKeys(keys iter.Seq[any]) []uint64 {
+	batches := make(chan []any)
+	results := make(chan []uint64)
+
+	// workers hash whole batches
+	wg := sync.WaitGroup{}
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				hashes := make([]uint64, len(batch))
+				for i, key := range batch {
+					hashes[i] = hash(b.Seeds[0], toBytes(key))
+				}
+				results <- hashes
+			}
+		}()
+	}
+
+	// read the iterator while the workers hash
+	go func() {
+		batch := make([]any, 0, ribbonBatchKeys)
+		for key := range keys {
+			batch = append(batch, key)
+			if len(batch) == ribbonBatchKeys {
+				batches <- batch
+				batch = make([]any, 0, ribbonBatchKeys)
+			}
+		}
+		if len(batch) > 0 {
+			batches <- batch
+		}
+		close(batches)
+		wg.Wait()
+		close(results)
+	}()
+
+	all := make([]uint64, 0)
+	for hashes := range results {
+		all = append(all, hashes...)
+	}
+	return all
+}
+
+// `shard`: shard of a key hash
+func (b *Ribbon) shard(h uint64) uint64 {
+	hi, _ := bits.Mul64(h, b.NShards)
+	return hi
+}
+
+// `row`: the row of a key hash in a shard of n_slots slots built with seed
+func (b *Ribbon) row(h, seed, n_slots uint64) ribbonRow {
+	h = mix64(h + seed)
+	start, _ := bits.Mul64(h, n_slots-ribbonWidth+1)
+	return ribbonRow{
+		start:  start,
+		coeff:  mix64(h^0x9e3779b97f4a7c15) | 1,
+		result: h & (uint64(1)<<b.RBits - 1),
+	}
+}
+
+// `solveShard`: band and back-substitute the keys of a shard, trying new seeds
+// and growing the shard on failure, return the seed and the slot values
+func (b *Ribbon) solveShard(hashes []uint64, si uint64) (uint64, []uint64, error) {
+	overhead := ribbonOverhead
+	rng := b.Seeds[1] ^ mix64(si+1)
+
+	for iteration := 0; iteration < ribbonMaxIterations; iteration++ {
+		if iteration > 0 && iteration%4 == 0 {
+			overhead *= 1.25
+		}
+		seed := splitmix64(&rng)
+		n_slots := uint64(math.Ceil(float64(len(hashes))*(1+overhead))) + ribbonWidth
+
+		if z, ok := b.band(hashes, seed, n_slots); ok {
+			return seed, z, nil
+		}
+	}
+
+	return 0, nil, fmt.Errorf("%w: ribbon shard %d unsolvable after %d attempts", ErrBuildFailed, si, ribbonMaxIterations)
+}
+
+// `band`: gaussian elimination into an upper triangular band, then back substitution
+func (b *Ribbon) band(hashes []uint64, seed, n_slots uint64) ([]uint64, bool) {
+	coeffs := make([]uint64, n_slots)
+	results := make([]uint64, n_slots)
+
+	for _, h := range hashes {
+		r := b.row(h, seed, n_slots)
+		s, c, v := r.start, r.coeff, r.result
+
+		for {
+			// free pivot, store the row
+			if coeffs[s] == 0 {
+				coeffs[s] = c
+				results[s] = v
+				break
+			}
+
+			// eliminate the pivot and move to the next set coefficient
+			c ^= coeffs[s]
+			v ^= results[s]
+			if c == 0 {
+				if v != 0 {
+					return nil, false
+				}
+				break
+			}
+			tz := uint64(bits.TrailingZeros64(c))
+			c >>= tz
+			s += tz
+		}
+	}
+
+	// back substitution, free slots are set to 0
+	z := make([]uint64, n_slots)
+	for i := int(n_slots) - 1; i >= 0; i-- {
+		c := coeffs[i]
+		if c == 0 {
+			continue
+		}
+		v := results[i]
+		for c &= c - 1; c != 0; c &= c - 1 {
+			v ^= z[i+bits.TrailingZeros64(c)]
+		}
+		z[i] = v
+	}
+	return z, true
+}
+
+// `Check`: check a value to the set (false negative: never, false positives: maybe)
+func (b *Ribbon) Check(value any) bool {
+	h := hash(b.Seeds[0], toBytes(value))
+	si := b.shard(h)
+
+	offset := b.ShardStarts[si]
+	r := b.row(h, b.ShardSeeds[si], b.ShardStarts[si+1]-offset)
+
+	v := uint64(0)
+	for c := r.coeff; c != 0; c &= c - 1 {
+		v ^= getPacked(b.Solution, offset+r.start+uint64(bits.TrailingZeros64(c)), b.RBits)
+	}
+	return v == r.result
+}
+
+// `GetFalsePositiveProbabilityEstimate`: false positive rate of RBits result bits
+func (b *Ribbon) GetFalsePositiveProbabilityEstimate() float64 {
+	return math.Exp2(-float64(b.RBits))
+}
+
+// `Save`: save the filter to dir/id.ribbon
+func (b *Ribbon) Save(dir string) error {
+	return saveGob(dir, b.ID+".ribbon", b)
+}
+
+// `Load`: load the filter from dir/id.ribbon
+func (b *Ribbon) Load(dir string) error {
+	return loadGob(dir, b.ID+".ribbon", b)
+}
@@ -0,0 +1,197 @@
+package bloom
+
+import (
+	"fmt"
+	"sync"
+)
+
+// a spilled set is sized for this many times the values it held when it spilled
+const setSpillGrowth = 8
+
+// `Set`: hybrid set that keeps its values in an exact map until it holds more than
+// Threshold of them, then spills into a `Bloom` (or a `BloomAtomic` with Atomic set)
+// sized for setSpillGrowth times that count at ProbFP, safe for concurrent use
+type Set struct {
+	ID        string
+	Threshold uint64
+	ProbFP    float64
+	Atomic    bool
+	Seeds     [2]uint64
+
+	mu    sync.RWMutex
+	exact map[string]struct{}
+	bloom IBloom
+}
+
+// `NewSetDefault` return a default `Set` spilling into a `Bloom`
+func NewSetDefault(id string, threshold uint64, prob_fp float64) *Set {
+	return NewSetCustom(id, threshold, prob_fp, false, [2]uint64{DefaultSeed1, DefaultSeed2})
+}
+
+// `NewSetCustom` return a custom `Set`, spilling into a `BloomAtomic` if atomic is set
+func NewSetCustom(id string, threshold uint64, prob_fp float64, atomic bool, seeds [2]uint64) *Set {
+
+	if prob_fp <= 0 || prob_fp >= 1 {
+		fmt.Println("NewSetCustom: prob_fp not in (0,1), using default 0.01")
+		prob_fp = 0.01
+	}
+
+	s := Set{
+		ID:        id,
+		Threshold: threshold,
+		ProbFP:    prob_fp,
+		Atomic:    atomic,
+		Seeds:     seeds,
+		exact:     make(map[string]struct{}),
+	}
+	return &s
+}
+
+// `newBloom`: empty bloom of the set's kind with the given parameters
+func (s *Set) newBloom(n_bits, n_hash uint64) IBloom {
+	if s.Atomic {
+		return NewBloomAtomicCustom(s.ID, n_bits, n_hash, s.Seeds)
+	}
+	return NewBloomCustom(s.ID, n_bits, n_hash, s.Seeds)
+}
+
+// `spill`: move the exact values into a bloom, with the parameters of state if given
+func (s *Set) spill(state *BloomDS) {
+	if state != nil {
+		s.bloom = s.newBloom(state.NBits, state.NHash)
+	} else {
+		n_add := max(uint64(len(s.exact)), 1) * setSpillGrowth
+		s.bloom = s.newBloom(GetOptimalParameters(n_add, s.ProbFP))
+	}
+	for key := range s.exact {
+		s.bloom.Add([]byte(key))
+	}
+	s.exact = nil
+}
+
+// `Add`: add a value to the set
+func (s *Set) Add(value any) {
+	// a spilled atomic bloom only needs the read lock
+	s.mu.RLock()
+	if s.bloom != nil && s.Atomic {
+		s.bloom.Add(value)
+		s.mu.RUnlock()
+		return
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bloom != nil {
+		s.bloom.Add(value)
+		return
+	}
+	s.exact[string(toBytes(value))] = struct{}{}
+	if uint64(len(s.exact)) > s.Threshold {
+		s.spill(nil)
+	}
+}
+
+// `Check`: check a value to the set (false negative: never, false positives: only once
+// the set has spilled)
+func (s *Set) Check(value any) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// `Bloom` writes take the write lock, so reads are safe here for both kinds
+	if s.bloom != nil {
+		return s.bloom.Check(value)
+	}
+	_, ok := s.exact[string(toBytes(value))]
+	return ok
+}
+
+// `IsExact`: true while the set has not spilled and its answers are exact
+func (s *Set) IsExact() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.bloom == nil
+}
+
+// `Len`: number of values of an exact set, 0 once the set has spilled
+func (s *Set) Len() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return uint64(len(s.exact))
+}
+
+// `Reset`: forget all values and go back to an exact map
+func (s *Set) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.exact = make(map[string]struct{})
+	s.bloom = nil
+}
+
+// `GetState`: return the state of the spilled bloom, false if the set is still exact
+func (s *Set) GetState() (BloomDS, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.bloom == nil {
+		return BloomDS{}, false
+	}
+	return s.bloom.GetState(), true
+}
+
+// `Union`: add the values of another set, an exact set spills if it crosses its threshold
+// or if the other set has spilled, in which case it takes the parameters of the other
+// bloom, false if the blooms can not be united (different parameters, seeds or hasher),
+// an exact set is left exact then
+func (s1 *Set) Union(s2 *Set) bool {
+	if s1 == s2 {
+		return true
+	}
+
+	// snapshot the other set first so the two locks are never held together
+	s2.mu.RLock()
+	keys := make([]string, 0, len(s2.exact))
+	for key := range s2.exact {
+		keys = append(keys, key)
+	}
+	var state *BloomDS
+	if s2.bloom != nil {
+		st := s2.bloom.GetState()
+		st.Filter = append([]uint64(nil), st.Filter...)
+		state = &st
+	}
+	s2.mu.RUnlock()
+
+	s1.mu.Lock()
+	defer s1.mu.Unlock()
+
+	// spilling keeps the seeds and the hasher of s1, only spill if the union can succeed
+	if s1.bloom == nil && state != nil {
+		if state.Seeds != s1.Seeds || state.hasherName() != HasherMurmur3 {
+			return false
+		}
+		s1.spill(state)
+	}
+
+	if s1.bloom != nil {
+		if state != nil {
+			return s1.bloom.Union(state)
+		}
+		for _, key := range keys {
+			s1.bloom.Add([]byte(key))
+		}
+		return true
+	}
+
+	for _, key := range keys {
+		s1.exact[key] = struct{}{}
+	}
+	if uint64(len(s1.exact)) > s1.Threshold {
+		s1.spill(nil)
+	}
+	return true
+}
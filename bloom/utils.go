@@ -3,9 +3,13 @@ package bloom
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
 
 	"github.com/twmb/murmur3"
@@ -22,50 +26,146 @@ func hash(seed uint64, data []byte) uint64 {
 	return murmur3.SeedSum64(seed, data)
 }
 
+// `getIndices`: double hashing of data into n_hash indices in [0, n_bits)
+func getIndices(data []byte, n_bits, n_hash uint64, seeds [2]uint64) []uint64 {
+	return getIndicesWith(murmur3Hasher{}, data, n_bits, n_hash, seeds)
+}
+
+// `getIndicesWith`: double hashing of data into n_hash indices in [0, n_bits) using h
+func getIndicesWith(h Hasher, data []byte, n_bits, n_hash uint64, seeds [2]uint64) []uint64 {
+	// get primary hashes
+	h1 := h.Sum64(seeds[0], data) % n_bits
+	h2 := h.Sum64(seeds[1], data) % n_bits
+
+	// use double hashing to generate n_hash indices
+	indices := make([]uint64, n_hash)
+	m := n_bits
+
+	for i := uint64(0); i < n_hash; i++ {
+		index := (h1 + (i*h2)%m) % m
+		indices[i] = index
+	}
+
+	return indices
+}
+
+// `getPacked`: read the i-th field of width bits from packed words
+func getPacked(words []uint64, i, width uint64) uint64 {
+	pos := i * width
+	wi := pos / 64
+	off := pos % 64
+	mask := uint64(1)<<width - 1
+
+	v := words[wi] >> off
+	if off+width > 64 {
+		// the field straddles two words
+		v |= words[wi+1] << (64 - off)
+	}
+	return v & mask
+}
+
+// `setPacked`: write the i-th field of width bits into packed words
+func setPacked(words []uint64, i, width, v uint64) {
+	pos := i * width
+	wi := pos / 64
+	off := pos % 64
+	mask := uint64(1)<<width - 1
+	v &= mask
+
+	words[wi] = words[wi]&^(mask<<off) | v<<off
+	if off+width > 64 {
+		// the field straddles two words
+		shift := 64 - off
+		words[wi+1] = words[wi+1]&^(mask>>shift) | v>>shift
+	}
+}
+
+// `packedWords`: number of words needed for n fields of width bits
+func packedWords(n, width uint64) uint64 {
+	return (n*width + 63) / 64
+}
+
 // `toBytes`: converts any to []byte
 func toBytes(value any) []byte {
+	data, err := toBytesE(value)
+	if err != nil {
+		// as a last resort, use fmt.Sprintf (non-deterministic but safe)
+		return []byte(fmt.Sprintf("%v", value))
+	}
+	return data
+}
+
+// `toBytesE`: converts any to []byte, an error if the JSON fallback fails
+func toBytesE(value any) ([]byte, error) {
 	switch v := value.(type) {
 	case nil:
-		return []byte("null")
+		return []byte("null"), nil
 
 	case []byte:
-		return v
+		return v, nil
 
 	case string:
-		return []byte(v)
+		return []byte(v), nil
 
 	case int, int8, int16, int32, int64:
 		buf := make([]byte, 8)
 		binary.BigEndian.PutUint64(buf, uint64(reflect.ValueOf(v).Int()))
-		return buf
+		return buf, nil
 
 	case uint, uint8, uint16, uint32, uint64:
 		buf := make([]byte, 8)
 		binary.BigEndian.PutUint64(buf, reflect.ValueOf(v).Uint())
-		return buf
+		return buf, nil
 
 	case float32:
 		buf := new(bytes.Buffer)
 		_ = binary.Write(buf, binary.BigEndian, v)
-		return buf.Bytes()
+		return buf.Bytes(), nil
 
 	case float64:
 		buf := new(bytes.Buffer)
 		_ = binary.Write(buf, binary.BigEndian, v)
-		return buf.Bytes()
+		return buf.Bytes(), nil
+
+	case netip.Addr:
+		// 16 bytes with ipv4 mapped and no zone, so an address has one encoding
+		ip := v.As16()
+		return ip[:], nil
 
 	case fmt.Stringer:
-		return []byte(v.String())
+		return []byte(v.String()), nil
 
 	default:
 		// fallback: deterministic JSON encoding
-		data, err := json.Marshal(v)
-		if err != nil {
-			// as a last resort, use fmt.Sprintf (non-deterministic but safe)
-			return []byte(fmt.Sprintf("%v", v))
-		}
-		return data
+		return json.Marshal(v)
+	}
+}
+
+// `saveGob`: save v to dir/fname using gob
+func saveGob(dir, fname string, v any) error {
+	// make dir if it doesnt exist
+	err := os.MkdirAll(dir, 0644)
+	if err != nil {
+		return err
+	}
+
+	// save to the file
+	f, err := os.Create(filepath.Join(dir, fname))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gob.NewEncoder(f).Encode(v)
+}
+
+// `loadGob`: load v from dir/fname using gob
+func loadGob(dir, fname string, v any) error {
+	f, err := os.Open(filepath.Join(dir, fname))
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+	return gob.NewDecoder(f).Decode(v)
 }
 
 // `GetPositiveProbablityEstimate`: returns probability of getting true, for a filter with n_bits, n_hash, and n_add Add operations.
@@ -76,10 +76,26 @@ func main() {
 	defer os.RemoveAll("./save_dir")
 
 	// create a bloom filter using the loaded state
-	bf := bloom.NewBloomFromBloomDS(&bds)
+	bf, err := bloom.NewBloomFromBloomDS(&bds)
+	if err != nil {
+		panic(err)
+	}
 
 	fmt.Println("not found 1:", bf.Check("palladium"))
 	fmt.Println("not found 2:", bf.Check(121))
 	fmt.Println("already present 1:", bf.Check(data1))
 	fmt.Println("already present 2:", bf.Check(data2))
+
+	// structs with only unexported fields all encode to {} by default, so any such
+	// struct is found, the canonical encoder includes the unexported fields
+	data5 := data2
+	data5.name = "Fido"
+	fmt.Println("default encoder, present:", bf.Check(data5))
+
+	bfc := bloom.NewBloomTypedDefault[any](id, n_bits, n_hash, bloom.CanonicalEncoder{TypeTags: true})
+	err = bfc.AddE(data2)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("canonical encoder, not found:", bfc.Check(data5))
 }